@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kwonalbert/pospace/posgraph"
+	"github.com/kwonalbert/pospace/util"
+	"io"
+	"os"
+	"time"
+)
+
+var graphTypes = map[string]int{
+	"type1": posgraph.TYPE1,
+	"egs":   posgraph.EGS,
+	"type2": posgraph.TYPE2,
+}
+
+var backends = map[string]int{
+	"bolt": posgraph.BOLT,
+	"flat": posgraph.FLAT,
+}
+
+// Flags shared by the graph commands
+type graphFlags struct {
+	typ     *string
+	index   *int64
+	dir     *string
+	backend *string
+}
+
+func newGraphFlags(fs *flag.FlagSet) *graphFlags {
+	return &graphFlags{
+		typ:     fs.String("type", "type2", "graph type ("+names(graphTypes)+")"),
+		index:   fs.Int64("index", 10, "graph index (the number of nodes for egs)"),
+		dir:     fs.String("dir", ".", "directory of the graph files"),
+		backend: fs.String("backend", "bolt", "storage backend ("+names(backends)+")"),
+	}
+}
+
+func (f *graphFlags) parse() (int, int, error) {
+	t, err := lookup(graphTypes, "graph type", *f.typ)
+	if err != nil {
+		return 0, 0, err
+	}
+	backend, err := lookup(backends, "backend", *f.backend)
+	if err != nil {
+		return 0, 0, err
+	}
+	return t, backend, nil
+}
+
+// Prints progress on a single line of w
+func progressPrinter(w io.Writer, what string) util.ProgressFunc {
+	return func(p util.Progress) {
+		percent := float64(100)
+		if p.Total > 0 {
+			percent = 100 * float64(p.Done) / float64(p.Total)
+		}
+		fmt.Fprintf(w, "\r%s: %d/%d (%.1f%%), %d MiB written, ETA %s    ",
+			what, p.Done, p.Total, percent, p.Bytes>>20, p.ETA.Round(time.Second))
+		if p.Done >= p.Total {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+func graphGen(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("graph gen", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	gf := newGraphFlags(fs)
+	seed := fs.String("seed", "", "public seed the random edges are derived from")
+	quiet := fs.Bool("q", false, "do not report progress")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	t, backend, err := gf.parse()
+	if err != nil {
+		return err
+	}
+
+	var progress util.ProgressFunc
+	if !*quiet {
+		progress = progressPrinter(stderr, "generating")
+	}
+	g, err := posgraph.NewGraphContext(ctx, t, *gf.dir, *gf.index, []byte(*seed), backend, progress)
+	if err != nil {
+		return err
+	}
+	defer g.Close()
+
+	fp, err := g.Fingerprint()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "size         %d\n", g.GetSize())
+	fmt.Fprintf(stdout, "fingerprint  %x\n", fp)
+	return nil
+}
+
+func graphInfo(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("graph info", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	gf := newGraphFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	t, backend, err := gf.parse()
+	if err != nil {
+		return err
+	}
+
+	if t == posgraph.TYPE1 {
+		// nothing stored; the graph is computed from the index
+		g := posgraph.NewType1Graph(t, *gf.index)
+		fmt.Fprintf(stdout, "type         type1\n")
+		fmt.Fprintf(stdout, "index        %d\n", *gf.index)
+		fp, err := g.Fingerprint()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "size         %d\n", g.GetSize())
+		fmt.Fprintf(stdout, "fingerprint  %x\n", fp)
+		return nil
+	}
+
+	fn, err := posgraph.FileName(t, *gf.dir, *gf.index, backend)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(fn); err != nil {
+		return fmt.Errorf("no graph at %s", fn)
+	}
+	db, err := posgraph.OpenDB(backend, fn, true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	meta, err := db.GetMeta()
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return errors.New("graph has no metadata; run graph gen to regenerate it")
+	}
+
+	fmt.Fprintf(stdout, "file         %s\n", fn)
+	fmt.Fprintf(stdout, "type         %s\n", *gf.typ)
+	fmt.Fprintf(stdout, "index        %d\n", meta.Index)
+	fmt.Fprintf(stdout, "size         %d\n", meta.Size)
+	fmt.Fprintf(stdout, "seed         %s\n", hex.EncodeToString(meta.Seed))
+	fmt.Fprintf(stdout, "version      %d\n", meta.Version)
+	fmt.Fprintf(stdout, "complete     %t\n", meta.Complete)
+	fmt.Fprintf(stdout, "fingerprint  %x\n", meta.Fingerprint)
+	return nil
+}
@@ -0,0 +1,110 @@
+// Command pospace runs the proof of space protocol from the command line
+//
+// Usage:
+//   pospace graph gen          generate and store an EGS or Type2 graph
+//   pospace graph info         show the metadata of a graph
+//   pospace prover init        initialize a space, and write its commitment
+//   pospace prover commit      write the commitment of an initialized space
+//   pospace prover prove       answer a challenges file with a proof file
+//   pospace prover serve       serve initialized spaces to verifiers over TCP
+//   pospace prover plots       list the initialized spaces in some directories
+//   pospace verifier challenge write a challenges file for a commitment
+//   pospace verifier verify    check a proof file against a commitment
+//
+// Commitments, challenges and proofs are files in their binary encodings
+// (see prover.Commitment, proof.MarshalChallenges and proof.Proof).
+// Run a command with -h for its flags.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+)
+
+// Exit status 1 is reserved for a rejected proof, everything else is 2
+var errRejected = errors.New("proof rejected")
+
+type command struct {
+	run   func(ctx context.Context, args []string, stdout, stderr io.Writer) error
+	usage string
+}
+
+var commands = map[string]command{
+	"graph gen":          {graphGen, "generate and store an EGS or Type2 graph"},
+	"graph info":         {graphInfo, "show the metadata of a graph"},
+	"prover init":        {proverInit, "initialize a space, and write its commitment"},
+	"prover commit":      {proverCommit, "write the commitment of an initialized space"},
+	"prover prove":       {proverProve, "answer a challenges file with a proof file"},
+	"prover serve":       {proverServe, "serve initialized spaces to verifiers over TCP"},
+	"prover plots":       {proverPlots, "list the initialized spaces in some directories"},
+	"verifier challenge": {verifierChallenge, "write a challenges file for a commitment"},
+	"verifier verify":    {verifierVerify, "check a proof file against a commitment"},
+}
+
+func main() {
+	// interrupting generation or Init stops it cleanly (Init resumes later),
+	// and interrupting serve shuts the server down
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	if err == errRejected {
+		fmt.Fprintln(os.Stderr, "pospace:", err)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "pospace:", err)
+		os.Exit(2)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	if len(args) < 2 {
+		usage(stderr)
+		return errors.New("missing command")
+	}
+	name := args[0] + " " + args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		usage(stderr)
+		return fmt.Errorf("unknown command %q", name)
+	}
+	return cmd.run(ctx, args[2:], stdout, stderr)
+}
+
+func usage(w io.Writer) {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: pospace <command> [flags]")
+	fmt.Fprintln(w, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-20s %s\n", name, commands[name].usage)
+	}
+}
+
+// Keys of m, for flag help
+func names(m map[string]int) string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
+func lookup(m map[string]int, what, name string) (int, error) {
+	v, ok := m[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown %s %q (want one of %s)", what, name, names(m))
+	}
+	return v, nil
+}
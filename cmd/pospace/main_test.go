@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func mustRun(args ...string) string {
+	var out bytes.Buffer
+	err := run(context.Background(), args, &out, io.Discard)
+	if err != nil {
+		log.Fatal(strings.Join(args, " "), " failed: ", err)
+	}
+	return out.String()
+}
+
+func TestWorkflow(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	file := func(name string) string { return dir + "/" + name }
+
+	mustRun("graph", "gen", "-type", "egs", "-index", "64", "-seed", "s", "-dir", dir, "-q")
+	info := mustRun("graph", "info", "-type", "egs", "-index", "64", "-dir", dir)
+	if !strings.Contains(info, "complete     true") {
+		log.Fatal("Unexpected graph info:\n", info)
+	}
+
+	pflags := []string{"-pk", "01", "-index", "3", "-graphdir", dir, "-spacedir", dir}
+	mustRun(append([]string{"prover", "init", "-q", "-commit", file("c1")}, pflags...)...)
+	mustRun(append([]string{"prover", "commit", "-commit", file("c2")}, pflags...)...)
+	c1, _ := os.ReadFile(file("c1"))
+	c2, _ := os.ReadFile(file("c2"))
+	if !bytes.Equal(c1, c2) {
+		log.Fatal("prover commit disagrees with prover init")
+	}
+
+	vflags := []string{"-commit", file("c1"), "-index", "3", "-graphdir", dir,
+		"-challenges", file("chal")}
+	mustRun(append([]string{"verifier", "challenge", "-seed", "abcd"}, vflags...)...)
+	mustRun(append([]string{"prover", "prove", "-mmap", "-challenges", file("chal"),
+		"-proof", file("proof")}, pflags...)...)
+	out := mustRun(append([]string{"verifier", "verify", "-proof", file("proof")}, vflags...)...)
+	if out != "ok\n" {
+		log.Fatal("Unexpected verify output: ", out)
+	}
+
+	// a proof for another commitment is rejected
+	mustRun(append([]string{"prover", "init", "-q", "-commit", file("c1"), "-hash", "blake2b"}, pflags...)...)
+	args := append([]string{"verifier", "verify", "-proof", file("proof")}, vflags...)
+	if err := run(context.Background(), args, io.Discard, io.Discard); err != errRejected {
+		log.Fatal("Verify accepted a proof for another commitment: ", err)
+	}
+
+	plots := mustRun("prover", "plots", "-graphdir", dir, "-spacedirs", dir)
+	if strings.Count(plots, "\n") != 2 || !strings.HasPrefix(plots, "01-") {
+		log.Fatal("Unexpected plots:\n", plots)
+	}
+
+	if err := run(context.Background(), []string{"prover", "nope"}, io.Discard, io.Discard); err == nil {
+		log.Fatal("Unknown command did not fail")
+	}
+}
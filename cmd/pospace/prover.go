@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kwonalbert/pospace/proof"
+	"github.com/kwonalbert/pospace/protocol"
+	"github.com/kwonalbert/pospace/prover"
+	"github.com/kwonalbert/pospace/util"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var hashes = map[string]int{
+	"sha3":    util.SHA3,
+	"sha256":  util.SHA256,
+	"blake2b": util.BLAKE2B,
+	"argon2":  util.ARGON2,
+}
+
+// Flags shared by the prover commands
+type proverFlags struct {
+	pk       *string
+	index    *int64
+	plot     *int64
+	hash     *string
+	graphDir *string
+	spaceDir *string
+	mmap     *bool
+}
+
+func newProverFlags(fs *flag.FlagSet) *proverFlags {
+	return &proverFlags{
+		pk:       fs.String("pk", "", "public key of the prover, in hex"),
+		index:    fs.Int64("index", 10, "graph index"),
+		plot:     fs.Int64("plot", 0, "plot id, to keep several spaces of the same pk and index"),
+		hash:     fs.String("hash", "sha3", "hash function ("+names(hashes)+")"),
+		graphDir: fs.String("graphdir", ".", "directory of the graph files"),
+		spaceDir: fs.String("spacedir", ".", "directory of the space file"),
+		mmap:     fs.Bool("mmap", false, "memory map the space, where the platform allows it"),
+	}
+}
+
+func (f *proverFlags) parse() ([]byte, int, error) {
+	if *f.pk == "" {
+		return nil, 0, errors.New("-pk is required")
+	}
+	pk, err := hex.DecodeString(*f.pk)
+	if err != nil {
+		return nil, 0, fmt.Errorf("bad -pk: %v", err)
+	}
+	hash, err := lookup(hashes, "hash function", *f.hash)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pk, hash, nil
+}
+
+// Open the initialized space described by f
+func (f *proverFlags) open() (*prover.Prover, error) {
+	pk, hash, err := f.parse()
+	if err != nil {
+		return nil, err
+	}
+	p, err := prover.OpenPlot(pk, *f.index, *f.plot, hash, *f.graphDir, *f.spaceDir)
+	if err != nil {
+		return nil, err
+	}
+	f.setStorage(p)
+	return p, nil
+}
+
+func (f *proverFlags) setStorage(p *prover.Prover) {
+	if *f.mmap {
+		p.SetStorage(prover.MMAP)
+	}
+}
+
+func writeCommitment(fn string, commit *prover.Commitment) error {
+	data, err := commit.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fn, data, 0644)
+}
+
+func readCommitment(fn string) (*prover.Commitment, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	commit := new(prover.Commitment)
+	if err := commit.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	return commit, nil
+}
+
+func proverInit(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("prover init", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	pf := newProverFlags(fs)
+	out := fs.String("commit", "commitment.bin", "file to write the commitment to")
+	workers := fs.Int("workers", 0, "goroutines labeling the graph (0 for one per CPU)")
+	shardSize := fs.Int64("shardsize", 0, "bytes per shard, to spread the space over -sharddirs (0 for one file)")
+	shardDirs := fs.String("sharddirs", "", "comma separated directories of the shards")
+	quiet := fs.Bool("q", false, "do not report progress")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	pk, hash, err := pf.parse()
+	if err != nil {
+		return err
+	}
+
+	p, err := prover.NewPlot(pk, *pf.index, *pf.plot, hash, *pf.graphDir, *pf.spaceDir)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	if *workers > 0 {
+		p.SetWorkers(*workers)
+	}
+	pf.setStorage(p)
+	if *shardSize > 0 {
+		if err := p.SetShards(*shardSize, strings.Split(*shardDirs, ",")...); err != nil {
+			return err
+		}
+	}
+
+	var progress util.ProgressFunc
+	if !*quiet {
+		progress = progressPrinter(stderr, "initializing")
+	}
+	commit, err := p.InitContext(ctx, progress)
+	if err != nil {
+		return err
+	}
+	if err := writeCommitment(*out, commit); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "root         %x\n", commit.Commit)
+	return nil
+}
+
+func proverCommit(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("prover commit", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	pf := newProverFlags(fs)
+	out := fs.String("commit", "commitment.bin", "file to write the commitment to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	p, err := pf.open()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	commit, err := p.PreInit()
+	if err != nil {
+		return err
+	}
+	if err := writeCommitment(*out, commit); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "root         %x\n", commit.Commit)
+	return nil
+}
+
+func proverProve(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("prover prove", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	pf := newProverFlags(fs)
+	in := fs.String("challenges", "challenges.bin", "file to read the challenges from")
+	out := fs.String("proof", "proof.bin", "file to write the proof to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+	challenges, err := proof.UnmarshalChallenges(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *in, err)
+	}
+
+	p, err := pf.open()
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	pfs, err := p.ProveSpace(challenges)
+	if err != nil {
+		return err
+	}
+	data, err = pfs.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*out, data, 0644)
+}
+
+func proverServe(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("prover serve", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	pf := newProverFlags(fs)
+	indices := fs.String("indices", "", "comma separated graph indices to serve (instead of -index)")
+	listen := fs.String("listen", "127.0.0.1:7070", "address to listen on")
+	workers := fs.Int("workers", 0, "proofs computed at a time (0 for one per CPU)")
+	rate := fs.Float64("rate", 0, "challenge requests per second allowed to each client host (0 for no limit)")
+	burst := fs.Int("burst", 10, "challenge requests a client host may send in a burst")
+	maxChalls := fs.Int("maxchallenges", protocol.DefaultMaxChallenges, "challenges in one request (0 for no limit)")
+	maxConns := fs.Int("maxconns", 0, "concurrent connections (0 for no limit)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	pk, hash, err := pf.parse()
+	if err != nil {
+		return err
+	}
+	idxs := []int64{*pf.index}
+	if *indices != "" {
+		idxs = nil
+		for _, f := range strings.Split(*indices, ",") {
+			index, err := strconv.ParseInt(strings.TrimSpace(f), 10, 64)
+			if err != nil {
+				return fmt.Errorf("bad -indices: %v", err)
+			}
+			idxs = append(idxs, index)
+		}
+	}
+
+	var s *protocol.Server
+	for _, index := range idxs {
+		p, err := prover.OpenPlot(pk, index, *pf.plot, hash, *pf.graphDir, *pf.spaceDir)
+		if err != nil {
+			return err
+		}
+		defer p.Close()
+		pf.setStorage(p)
+		if s == nil {
+			s, err = protocol.NewServer(p)
+		} else {
+			err = s.AddSpace(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if *workers > 0 {
+		s.SetWorkers(*workers)
+	}
+	s.SetRateLimit(*rate, *burst)
+	s.SetMaxChallenges(*maxChalls)
+	s.SetMaxConns(*maxConns)
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "listening    %s\n", l.Addr())
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+	err = s.Serve(l)
+	if err == protocol.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func proverPlots(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("prover plots", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	graphDir := fs.String("graphdir", ".", "directory of the graph files")
+	spaceDirs := fs.String("spacedirs", ".", "comma separated directories of the space files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	m, err := prover.NewManager(*graphDir, strings.Split(*spaceDirs, ",")...)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	for _, key := range m.Plots() {
+		p, err := m.Prover(key)
+		if err != nil {
+			return err
+		}
+		dir, err := m.Dir(key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%-40s %12d  %s\n", key, p.SpaceSize(), dir)
+	}
+	fmt.Fprintf(stdout, "total        %d bytes\n", m.CommittedBytes())
+	return nil
+}
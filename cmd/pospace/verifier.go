@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/kwonalbert/pospace/proof"
+	"github.com/kwonalbert/pospace/verifier"
+	"io"
+	"os"
+)
+
+// Flags shared by the verifier commands
+type verifierFlags struct {
+	commit   *string
+	index    *int64
+	beta     *int
+	graphDir *string
+}
+
+func newVerifierFlags(fs *flag.FlagSet) *verifierFlags {
+	return &verifierFlags{
+		commit:   fs.String("commit", "commitment.bin", "file to read the prover's commitment from"),
+		index:    fs.Int64("index", 10, "graph index"),
+		beta:     fs.Int("beta", 1, "beta*log2(size) challenges are selected"),
+		graphDir: fs.String("graphdir", ".", "directory of the graph files"),
+	}
+}
+
+// Verifier for the commitment in f, which must be for the same graph
+func (f *verifierFlags) open() (*verifier.Verifier, error) {
+	commit, err := readCommitment(*f.commit)
+	if err != nil {
+		return nil, err
+	}
+	v, err := verifier.NewVerifier(commit.Pk, *f.index, commit.Hash, *f.beta, commit.Commit, *f.graphDir)
+	if err != nil {
+		return nil, err
+	}
+	v.SetPlot(commit.Plot)
+	if !v.VerifyGraph(commit.Graph) {
+		return nil, errors.New("the commitment is for a different graph")
+	}
+	return v, nil
+}
+
+func verifierChallenge(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("verifier challenge", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	vf := newVerifierFlags(fs)
+	seedHex := fs.String("seed", "", "seed for the challenges, in hex (random if empty)")
+	out := fs.String("challenges", "challenges.bin", "file to write the challenges to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var seed []byte
+	var err error
+	if *seedHex != "" {
+		seed, err = hex.DecodeString(*seedHex)
+		if err != nil {
+			return fmt.Errorf("bad -seed: %v", err)
+		}
+	} else {
+		seed = make([]byte, 32)
+		if _, err := rand.Read(seed); err != nil {
+			return err
+		}
+	}
+
+	v, err := vf.open()
+	if err != nil {
+		return err
+	}
+	challenges := v.SelectChallenges(seed)
+	if err := os.WriteFile(*out, proof.MarshalChallenges(challenges), 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "challenges   %d\n", len(challenges))
+	return nil
+}
+
+func verifierVerify(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("verifier verify", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	vf := newVerifierFlags(fs)
+	in := fs.String("challenges", "challenges.bin", "file to read the challenges from")
+	pfn := fs.String("proof", "proof.bin", "file to read the proof from")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		return err
+	}
+	challenges, err := proof.UnmarshalChallenges(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *in, err)
+	}
+	data, err = os.ReadFile(*pfn)
+	if err != nil {
+		return err
+	}
+	pf := new(proof.Proof)
+	if err := pf.UnmarshalBinary(data); err != nil {
+		return fmt.Errorf("%s: %v", *pfn, err)
+	}
+
+	v, err := vf.open()
+	if err != nil {
+		return err
+	}
+	if !v.VerifySpace(challenges, pf) {
+		return errRejected
+	}
+	fmt.Fprintln(stdout, "ok")
+	return nil
+}
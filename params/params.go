@@ -0,0 +1,90 @@
+// Package params computes a lower bound on how many challenges the
+// verifier needs.
+//
+// If a prover that discarded a fraction discard of its labels cannot
+// recompute a discarded label within the response time, each uniform
+// challenge catches it with probability at least discard, and n
+// independent challenges let it pass with probability (1-discard)^n.
+//
+// Whether it can recompute labels in time depends on the pebbling
+// complexity of the graph, which is not modelled here: the bound ignores
+// the graph type and index, so it is the least number of challenges for a
+// soundness, not a guarantee of that soundness for any given graph. The
+// graph only matters for MinBeta, which spreads the challenges over the
+// merkle tree depth.
+package params
+
+import (
+	"errors"
+	"github.com/kwonalbert/pospace/posgraph"
+	"github.com/kwonalbert/pospace/util"
+	"math/big"
+)
+
+var ErrParams = errors.New("params: discard and soundness must be in (0, 1)")
+
+// return: smallest n such that (1-discard)^n <= soundness
+func MinChallenges(discard, soundness float64) (int64, error) {
+	if discard <= 0 || discard >= 1 || soundness <= 0 || soundness >= 1 {
+		return 0, ErrParams
+	}
+
+	keep := big.NewFloat(1 - discard)
+	target := big.NewFloat(soundness)
+	passes := func(n int64) bool {
+		return util.Pow(keep, n).Cmp(target) > 0
+	}
+
+	// double until enough, then binary search the boundary
+	hi := int64(1)
+	for passes(hi) {
+		hi *= 2
+	}
+	lo := hi / 2
+	for lo+1 < hi {
+		mid := (lo + hi) / 2
+		if passes(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, nil
+}
+
+// return: soundness error of n challenges against a prover that discarded
+//         a fraction discard of the space and cannot recompute it in time
+//         (a lower bound otherwise)
+func Soundness(discard float64, n int64) float64 {
+	res, _ := util.Pow(big.NewFloat(1-discard), n).Float64()
+	return res
+}
+
+// return: largest fraction of the space a prover can discard and still
+//         pass n challenges with probability soundness
+func Discard(soundness float64, n int64) float64 {
+	keep, _ := util.Root(big.NewFloat(soundness), n).Float64()
+	return 1 - keep
+}
+
+// return: least beta for verifier.NewVerifier, which selects beta*log2
+//         challenges where log2 is the depth of the merkle tree over the
+//         graph, to reach at least MinChallenges
+func MinBeta(t int, index int64, discard, soundness float64) (int, error) {
+	n, err := MinChallenges(discard, soundness)
+	if err != nil {
+		return 0, err
+	}
+	size, err := posgraph.Size(t, index)
+	if err != nil {
+		return 0, err
+	}
+	log2 := util.Log2(size) + 1
+	if (1 << uint64(log2-1)) == size {
+		log2--
+	}
+	if log2 < 1 {
+		log2 = 1
+	}
+	return int((n + log2 - 1) / log2), nil
+}
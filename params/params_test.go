@@ -0,0 +1,39 @@
+package params
+
+import (
+	"github.com/kwonalbert/pospace/posgraph"
+	"log"
+	"math"
+	"testing"
+)
+
+func TestMinChallenges(t *testing.T) {
+	n, err := MinChallenges(0.1, 1e-6)
+	if err != nil {
+		log.Fatal("Challenges failed:", err)
+	}
+	exp := int64(math.Ceil(math.Log(1e-6) / math.Log(0.9)))
+	if n != exp {
+		log.Fatal("Wrong number of challenges:", n, exp)
+	}
+	if Soundness(0.1, n) > 1e-6 || Soundness(0.1, n-1) <= 1e-6 {
+		log.Fatal("Challenges is not tight:", n)
+	}
+	if d := Discard(1e-6, n); d > 0.1 || d < 0.09 {
+		log.Fatal("Wrong discard fraction:", d)
+	}
+
+	beta, err := MinBeta(posgraph.TYPE1, 10, 0.1, 1e-6)
+	if err != nil {
+		log.Fatal("Beta failed:", err)
+	}
+	size, _ := posgraph.Size(posgraph.TYPE1, 10)
+	log2 := int64(math.Ceil(math.Log2(float64(size))))
+	if int64(beta)*log2 < n || int64(beta-1)*log2 >= n {
+		log.Fatal("Wrong beta:", beta, log2, n)
+	}
+
+	if _, err = MinChallenges(0, 1e-6); err != ErrParams {
+		log.Fatal("Accepted discard of 0")
+	}
+}
@@ -0,0 +1,206 @@
+package posgraph
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/boltdb/bolt"
+)
+
+// storage backends for graphs
+const (
+	BOLT   = iota // BoltDB file, the default
+	MEMORY = iota // in-memory maps; for tests and small indices
+	FLAT   = iota // fixed-width CSR file, read with ReadAt
+)
+
+// Storage for the parents and adjacency lists of the nodes in a graph
+type DB interface {
+	GetParents(id int64) ([]int64, error)
+	PutParents(id int64, parents []int64) error
+	PutParentsBatch(ids []int64, parents [][]int64) error
+	GetAdjacency(id int64) ([]int64, error)
+	PutAdjacency(id int64, adjlist []int64) error
+	GetMeta() (*Meta, error) // nil if the graph has no metadata yet
+	PutMeta(meta *Meta) error
+	Close() error
+}
+
+// Open the storage of type backend at fn
+// MEMORY ignores fn and readOnly, and always starts out empty
+func OpenDB(backend int, fn string, readOnly bool) (DB, error) {
+	var db DB
+	var err error
+	if backend == BOLT {
+		db, err = openBoltDB(fn, readOnly)
+	} else if backend == MEMORY {
+		db = newMemDB()
+	} else if backend == FLAT {
+		db, err = openFlatDB(fn, readOnly)
+	} else {
+		return nil, ErrUnknownBackend
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %w", ErrOpenDB, fn, err)
+	}
+	return db, nil
+}
+
+func encodeList(list []int64) []byte {
+	data := make([]byte, len(list)*8)
+	for i := range list {
+		binary.PutVarint(data[i*8:(i+1)*8], list[i])
+	}
+	return data
+}
+
+func decodeList(data []byte) []int64 {
+	list := make([]int64, len(data)/8)
+	for i := range list {
+		list[i], _ = binary.Varint(data[i*8 : (i+1)*8])
+	}
+	return list
+}
+
+type boltDB struct {
+	db *bolt.DB
+}
+
+func openBoltDB(fn string, readOnly bool) (*boltDB, error) {
+	db, err := bolt.Open(fn, 0600, &bolt.Options{ReadOnly: readOnly})
+	if err != nil {
+		return nil, err
+	}
+	if readOnly {
+		return &boltDB{db}, nil
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"Parents", "Adjlist", "Meta"} {
+			_, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return fmt.Errorf("create bucket: %s", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltDB{db}, nil
+}
+
+func (db *boltDB) get(bucket string, id int64) ([]int64, error) {
+	key := make([]byte, 8)
+	binary.PutVarint(key, id)
+
+	var data []byte
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		d := b.Get(key)
+		data = make([]byte, len(d))
+		copy(data, d)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeList(data), nil
+}
+
+func (db *boltDB) put(bucket string, id int64, list []int64) error {
+	key := make([]byte, 8)
+	binary.PutVarint(key, id)
+	data := encodeList(list)
+
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		return b.Put(key, data)
+	})
+}
+
+func (db *boltDB) GetParents(id int64) ([]int64, error) {
+	return db.get("Parents", id)
+}
+
+func (db *boltDB) PutParents(id int64, parents []int64) error {
+	return db.put("Parents", id, parents)
+}
+
+// All of the puts share one transaction (and one fsync)
+func (db *boltDB) PutParentsBatch(ids []int64, parents [][]int64) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Parents"))
+		for i := range ids {
+			key := make([]byte, 8)
+			binary.PutVarint(key, ids[i])
+			err := b.Put(key, encodeList(parents[i]))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db *boltDB) GetAdjacency(id int64) ([]int64, error) {
+	return db.get("Adjlist", id)
+}
+
+func (db *boltDB) PutAdjacency(id int64, adjlist []int64) error {
+	return db.put("Adjlist", id, adjlist)
+}
+
+// keys of the Meta fields in the Meta bucket; the seed and fingerprint
+// are under "seed" and "fingerprint"
+var metaKeys = []string{"type", "index", "size", "version", "complete"}
+
+func (db *boltDB) GetMeta() (*Meta, error) {
+	var meta *Meta
+	err := db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Meta"))
+		if b == nil || b.Get([]byte("complete")) == nil {
+			return nil
+		}
+		fields := make([]int64, len(metaKeys))
+		for i, key := range metaKeys {
+			list := decodeList(b.Get([]byte(key)))
+			if len(list) != 1 {
+				return ErrMeta
+			}
+			fields[i] = list[0]
+		}
+		meta = &Meta{
+			Seed:        append([]byte{}, b.Get([]byte("seed"))...),
+			Fingerprint: append([]byte{}, b.Get([]byte("fingerprint"))...),
+		}
+		meta.setFields(fields)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// All of the fields are updated in one transaction
+func (db *boltDB) PutMeta(meta *Meta) error {
+	return db.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("Meta"))
+		for i, v := range meta.fields() {
+			err := b.Put([]byte(metaKeys[i]), encodeList([]int64{v}))
+			if err != nil {
+				return err
+			}
+		}
+		err := b.Put([]byte("seed"), meta.Seed)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("fingerprint"), meta.Fingerprint)
+	})
+}
+
+func (db *boltDB) Close() error {
+	return db.db.Close()
+}
@@ -1,6 +1,7 @@
 package posgraph
 
 import (
+	"context"
 	//"fmt"
 	"github.com/kwonalbert/pospace/util"
 )
@@ -10,10 +11,11 @@ type EGSGraph struct {
 }
 
 // generate graph according to "On sparse graphs with dense long paths"
-func NewEGSGraph(t int, gen bool, size int64, db DB) *EGSGraph {
+func NewEGSGraph(t int, gen bool, size int64, seed []byte, db DB) (*EGSGraph, error) {
 	g := &EGSGraph{
 		Graph_{
 			size: size,
+			seed: seed,
 			t:    EGS,
 		},
 	}
@@ -30,10 +32,13 @@ func NewEGSGraph(t int, gen bool, size int64, db DB) *EGSGraph {
 	g.db = db
 
 	if gen {
-		g.EGSGraph()
+		err := g.EGSGraph()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return g
+	return g, nil
 }
 
 func (g *EGSGraph) dGraph(v, m int64) []int64 {
@@ -44,18 +49,24 @@ func (g *EGSGraph) dGraph(v, m int64) []int64 {
 	return d
 }
 
-func (g *EGSGraph) EGSGraph() {
-	// create 2^n-1 vertices, and edges
-	// (i) from the paper
-	for i := int64(1); i <= g.size; i++ {
-		var parents []int64
-		for j := util.Max(0, i-4*g.log2); j < i; j++ {
-			parents = append(parents, j)
-		}
-		g.NewNodeP(i, parents)
+func (g *EGSGraph) generate(ctx context.Context, fn util.ProgressFunc) error {
+	total := g.size
+	g.rounds(func(t, m, i, tpow2 int64) error {
+		total += int64(len(g.dGraph(m*tpow2, tpow2)))
+		return nil
+	})
+	g.progress = util.NewTracker(ctx, total, fn)
+	defer func() { g.progress = nil }()
+	err := g.EGSGraph()
+	if err != nil {
+		return err
 	}
+	g.progress.Finish()
+	return nil
+}
 
-	// (ii) from the paper
+// Call f for each (t, m, i) round of (ii) from the paper, in order
+func (g *EGSGraph) rounds(f func(t, m, i, tpow2 int64) error) error {
 	tBound := util.Log2(g.log2/2) + 1
 	if (1 << uint64(tBound-1)) == (g.log2 / 2) {
 		tBound--
@@ -68,29 +79,70 @@ func (g *EGSGraph) EGSGraph() {
 				if (m+i)*tpow2 > g.size {
 					continue
 				}
-				//TODO: figure out what ep1 is really..
-				ep1 := float64(0.88)
-				srcs := g.dGraph(m*tpow2, tpow2)
-				sinks := g.dGraph((m+1)*tpow2, tpow2)
-				g.bipartiteGraph(srcs, sinks, ep1)
+				err := f(t, m, i, tpow2)
+				if err != nil {
+					return err
+				}
 			}
 		}
 	}
+	return nil
+}
+
+func (g *EGSGraph) EGSGraph() error {
+	// create 2^n-1 vertices, and edges
+	// (i) from the paper
+	for i := int64(1); i <= g.size; i++ {
+		var parents []int64
+		for j := util.Max(0, i-4*g.log2); j < i; j++ {
+			parents = append(parents, j)
+		}
+		err := g.addParents(i, parents)
+		if err != nil {
+			return err
+		}
+		err = g.progress.Add(1, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	// (ii) from the paper
+	err := g.rounds(func(t, m, i, tpow2 int64) error {
+		//TODO: figure out what ep1 is really..
+		ep1 := float64(0.88)
+		srcs := g.dGraph(m*tpow2, tpow2)
+		sinks := g.dGraph((m+1)*tpow2, tpow2)
+		prng := []int64{t, m, i}
+		return g.bipartiteGraph(srcs, sinks, ep1, prng)
+	})
+	if err != nil {
+		return err
+	}
+	return g.flush()
 }
 
 // Generates a bipartite graph that satisfies Lemma 1 from the paper
 // TODO: currently generates a random bipartite graph
 //       should check if the generated graph satisfies the properties
-func (g *EGSGraph) bipartiteGraph(srcs, sinks []int64, delta float64) {
+// The edges of source s are drawn from util.NewPRNG(seed, round..., s),
+// where round identifies the (t, m, i) iteration of EGSGraph
+func (g *EGSGraph) bipartiteGraph(srcs, sinks []int64, delta float64, round []int64) error {
 	numEdges := int64(delta * float64(len(sinks)))
 
-	// TODO: make this more efficient;
-	//       currently reads the node everytime to update parents list..
 	for _, s := range srcs {
-		vals := util.NRandRange(0, int64(len(sinks)), numEdges)
+		prng := util.NewPRNG(g.seed, append(round, s)...)
+		vals := prng.NRandRange(0, int64(len(sinks)), numEdges)
 		for i := range vals {
-			parents := util.Union(g.GetParents(sinks[vals[i]]), []int64{s})
-			g.NewNodeP(sinks[vals[i]], parents)
+			err := g.addParents(sinks[vals[i]], []int64{s})
+			if err != nil {
+				return err
+			}
+		}
+		err := g.progress.Add(1, 0)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
 }
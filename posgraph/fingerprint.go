@@ -0,0 +1,64 @@
+package posgraph
+
+import (
+	"encoding/binary"
+	"golang.org/x/crypto/sha3"
+)
+
+// Hash of the structure of g: SHA3-256 over the size, then the parent
+// list (length, then the parents) of every id in [0, size], in id order
+// Two stored graphs have the same fingerprint iff they have the same edges
+func fingerprint(g Graph) ([]byte, error) {
+	h := sha3.New256()
+	buf := make([]byte, 8)
+	write := func(v int64) {
+		binary.BigEndian.PutUint64(buf, uint64(v))
+		h.Write(buf)
+	}
+
+	h.Write([]byte("pospace graph"))
+	write(g.GetSize())
+	for id := int64(0); id <= g.GetSize(); id++ {
+		parents, err := g.GetParents(id)
+		if err != nil {
+			return nil, err
+		}
+		write(int64(len(parents)))
+		for _, p := range parents {
+			write(p)
+		}
+	}
+	return h.Sum(nil), nil
+}
+
+// Stored graphs keep their fingerprint in their metadata, so it is only
+// computed once, right after generation
+func (g *Graph_) Fingerprint() ([]byte, error) {
+	if g.fp != nil {
+		return g.fp, nil
+	}
+	meta, err := g.db.GetMeta()
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil && meta.Complete && len(meta.Fingerprint) > 0 {
+		g.fp = meta.Fingerprint
+		return g.fp, nil
+	}
+	g.fp, err = fingerprint(g)
+	return g.fp, err
+}
+
+// Type1 graphs are computed rather than stored, so their edges only
+// depend on the index and the layout of GetParents (Type1Version); those
+// are hashed instead of walking the graph
+func (g *Type1Graph) Fingerprint() ([]byte, error) {
+	h := sha3.New256()
+	buf := make([]byte, 8)
+	h.Write([]byte("pospace type1 graph"))
+	for _, v := range []int64{TYPE1, Type1Version, g.index, g.size} {
+		binary.BigEndian.PutUint64(buf, uint64(v))
+		h.Write(buf)
+	}
+	return h.Sum(nil), nil
+}
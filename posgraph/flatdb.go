@@ -0,0 +1,240 @@
+package posgraph
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// Flat file storage with fixed-width entries in CSR form:
+//   n, #parents, #adjacencies             (3 x uint64)
+//   parent offsets (n+1 x uint64), parents (int64 each)
+//   adjacency offsets (n+1 x uint64), adjacencies (int64 each)
+//   metadata (see Meta.marshal), then its length (uint64)
+// where n is the largest node id + 1 and the lists of node i are
+// list[offsets[i]:offsets[i+1]].
+// The file is written once on Close; while writing, lists are kept in
+// memory (graph generation updates lists in random order).
+type flatDB struct {
+	fn string
+	*memDB
+
+	f          *os.File
+	n          int64
+	parentsOff int64 // file offset of the parent offsets
+	adjlistOff int64 // file offset of the adjacency offsets
+	metaOff    int64 // file offset of the metadata
+}
+
+const flatHeaderSize = 3 * 8
+
+var errReadOnly = errors.New("flat graph file is read only")
+
+func openFlatDB(fn string, readOnly bool) (*flatDB, error) {
+	if !readOnly {
+		return &flatDB{fn: fn, memDB: newMemDB()}, nil
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, flatHeaderSize)
+	_, err = f.ReadAt(header, 0)
+	if err == io.EOF {
+		// cut short before the header, so there is no metadata either
+		err = ErrMeta
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	n := int64(binary.LittleEndian.Uint64(header[0:]))
+	numParents := int64(binary.LittleEndian.Uint64(header[8:]))
+	numAdjlist := int64(binary.LittleEndian.Uint64(header[16:]))
+
+	db := &flatDB{
+		fn:         fn,
+		f:          f,
+		n:          n,
+		parentsOff: flatHeaderSize,
+		adjlistOff: flatHeaderSize + (n+1)*8 + numParents*8,
+	}
+	db.metaOff = db.adjlistOff + (n+1)*8 + numAdjlist*8
+	return db, nil
+}
+
+func (db *flatDB) get(off, id int64) ([]int64, error) {
+	if id < 0 || id >= db.n {
+		return nil, nil
+	}
+	buf := make([]byte, 16)
+	_, err := db.f.ReadAt(buf, off+id*8)
+	if err != nil {
+		return nil, err
+	}
+	start := int64(binary.LittleEndian.Uint64(buf[0:]))
+	end := int64(binary.LittleEndian.Uint64(buf[8:]))
+
+	data := make([]byte, (end-start)*8)
+	_, err = db.f.ReadAt(data, off+(db.n+1)*8+start*8)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]int64, end-start)
+	for i := range list {
+		list[i] = int64(binary.LittleEndian.Uint64(data[i*8:]))
+	}
+	return list, nil
+}
+
+func (db *flatDB) GetParents(id int64) ([]int64, error) {
+	if db.memDB != nil {
+		return db.memDB.GetParents(id)
+	}
+	return db.get(db.parentsOff, id)
+}
+
+func (db *flatDB) GetAdjacency(id int64) ([]int64, error) {
+	if db.memDB != nil {
+		return db.memDB.GetAdjacency(id)
+	}
+	return db.get(db.adjlistOff, id)
+}
+
+func (db *flatDB) PutParents(id int64, parents []int64) error {
+	if db.memDB == nil {
+		return errReadOnly
+	}
+	return db.memDB.PutParents(id, parents)
+}
+
+func (db *flatDB) PutParentsBatch(ids []int64, parents [][]int64) error {
+	if db.memDB == nil {
+		return errReadOnly
+	}
+	return db.memDB.PutParentsBatch(ids, parents)
+}
+
+func (db *flatDB) PutAdjacency(id int64, adjlist []int64) error {
+	if db.memDB == nil {
+		return errReadOnly
+	}
+	return db.memDB.PutAdjacency(id, adjlist)
+}
+
+// A file cut short during Close has no (or a mismatched) metadata trailer
+func (db *flatDB) GetMeta() (*Meta, error) {
+	if db.memDB != nil {
+		return db.memDB.GetMeta()
+	}
+	info, err := db.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < db.metaOff+8 {
+		return nil, nil
+	}
+	buf := make([]byte, 8)
+	_, err = db.f.ReadAt(buf, info.Size()-8)
+	if err != nil {
+		return nil, err
+	}
+	if int64(binary.LittleEndian.Uint64(buf)) != info.Size()-8-db.metaOff {
+		return nil, ErrMeta
+	}
+	data := make([]byte, info.Size()-8-db.metaOff)
+	_, err = db.f.ReadAt(data, db.metaOff)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalMeta(data)
+}
+
+func (db *flatDB) PutMeta(meta *Meta) error {
+	if db.memDB == nil {
+		return errReadOnly
+	}
+	return db.memDB.PutMeta(meta)
+}
+
+func writeUint64(w *bufio.Writer, v uint64) error {
+	var entry [8]byte
+	binary.LittleEndian.PutUint64(entry[:], v)
+	_, err := w.Write(entry[:])
+	return err
+}
+
+// write out one CSR section of lists for ids [0, n)
+func writeSection(w *bufio.Writer, n int64, lists map[int64][]int64) error {
+	total := uint64(0)
+	for i := int64(0); i <= n; i++ {
+		if err := writeUint64(w, total); err != nil {
+			return err
+		}
+		total += uint64(len(lists[i]))
+	}
+	for i := int64(0); i < n; i++ {
+		for _, v := range lists[i] {
+			if err := writeUint64(w, uint64(v)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (db *flatDB) flush() error {
+	n := int64(0)
+	numLists := []int64{0, 0}
+	for i, lists := range []map[int64][]int64{db.parents, db.adjlist} {
+		for id, list := range lists {
+			if id+1 > n {
+				n = id + 1
+			}
+			numLists[i] += int64(len(list))
+		}
+	}
+
+	f, err := os.OpenFile(db.fn, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, v := range []int64{n, numLists[0], numLists[1]} {
+		if err = writeUint64(w, uint64(v)); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	for _, lists := range []map[int64][]int64{db.parents, db.adjlist} {
+		if err = writeSection(w, n, lists); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if db.meta != nil {
+		meta := db.meta.marshal()
+		if _, err = w.Write(meta); err == nil {
+			err = writeUint64(w, uint64(len(meta)))
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (db *flatDB) Close() error {
+	if db.memDB != nil {
+		return db.flush()
+	}
+	return db.f.Close()
+}
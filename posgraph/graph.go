@@ -1,12 +1,12 @@
 package posgraph
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/boltdb/bolt"
+	"github.com/kwonalbert/pospace/util"
 	"os"
-	// "reflect"
-	// "unsafe"
+	"sort"
 )
 
 const (
@@ -15,14 +15,24 @@ const (
 	TYPE2 = iota
 )
 
-// creating another DB type so it's easier to change underlying DB later
-type DB struct {
-	db *bolt.DB
-}
+var (
+	ErrUnknownType    = errors.New("posgraph: unknown graph type")
+	ErrUnknownBackend = errors.New("posgraph: unknown storage backend")
+	ErrOpenDB         = errors.New("posgraph: failed to open database")
+)
+
+// Number of nodes whose new edges are buffered during generation before
+// they are committed together in one batch
+var batchSize = 1 << 16
 
 type Graph_ struct {
-	fn string
-	db DB
+	fn   string
+	db   DB
+	seed []byte // public seed all random edges are derived from
+	fp   []byte // cached Fingerprint
+
+	pending  map[int64][]int64 // edges buffered during generation
+	progress *util.Tracker     // of generation; nil if not tracked
 
 	index int64
 	log2  int64
@@ -33,165 +43,236 @@ type Graph_ struct {
 }
 
 type Graph interface {
-	NewNodeP(id int64, parents []int64)
-	GetParents(id int64) []int64
-	NewNodeA(id int64, adjlist []int64)
-	GetAdjacency(id int64) []int64
+	NewNodeP(id int64, parents []int64) error
+	GetParents(id int64) ([]int64, error)
+	NewNodeA(id int64, adjlist []int64) error
+	GetAdjacency(id int64) ([]int64, error)
 	GetSize() int64
+	Fingerprint() ([]byte, error)
 	GetDB() DB
 	ChangeDB(DB)
 	Close()
 }
 
+// Graphs whose edges are generated and stored
+type generator interface {
+	Graph
+	// generate the edges, reporting to fn; stops once ctx is cancelled
+	generate(ctx context.Context, fn util.ProgressFunc) error
+}
+
 // Generate a new PoS graph of index
 // Currently only supports the weaker PoS graph
 // Note that this graph will have O(2^index) nodes
-func NewGraph(t int, dir string, index int64) Graph {
-	var fn string
+// Any random edges are derived from the public seed, so every party
+// calling NewGraph with the same arguments gets the same graph
+// An existing graph file is reused only if its metadata says it is
+// complete and matches the arguments; otherwise it is regenerated
+// backend selects where the graph is stored (BOLT, MEMORY or FLAT)
+func NewGraph(t int, dir string, index int64, seed []byte, backend int) (Graph, error) {
+	return NewGraphContext(context.Background(), t, dir, index, seed, backend, nil)
+}
+
+// NewGraph, but generation stops with ctx.Err() once ctx is cancelled
+// (leaving no graph file behind), and is reported to progress (if not nil)
+// Progress is counted in source nodes whose edges were drawn, and in
+// bytes of parent lists written
+func NewGraphContext(ctx context.Context, t int, dir string, index int64, seed []byte,
+	backend int, progress util.ProgressFunc) (Graph, error) {
 	if t == TYPE1 {
-		fn = fmt.Sprintf("%s/T1-%d", dir, index)
-	} else if t == EGS {
-		fn = fmt.Sprintf("%s/EGS-%d", dir, index)
-	} else if t == TYPE2 {
-		fn = fmt.Sprintf("%s/T2-%d", dir, index)
+		// no storage needed, parents are computed on the fly
+		return NewType1Graph(t, index), nil
 	}
 
-	_, err := os.Stat(fn)
-	fileExists := err == nil
-
-	var db *bolt.DB
-	if fileExists { //open it as read only
-		db, err = bolt.Open(fn, 0600, &bolt.Options{ReadOnly: true})
-	} else {
-		db, err = bolt.Open(fn, 0600, nil)
-	}
+	fn, err := FileName(t, dir, index, backend)
 	if err != nil {
-		panic("Failed to open database")
+		return nil, err
 	}
 
-	db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucket([]byte("Parents"))
+	var db DB
+	if backend != MEMORY {
+		db, err = openComplete(backend, fn, t, index, seed)
 		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
+			return nil, err
 		}
-		return nil
-	})
-	db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucket([]byte("Adjlist"))
+	}
+	fileExists := db != nil
+
+	meta := &Meta{Type: t, Index: index, Seed: seed, Version: GeneratorVersion}
+	meta.Size, _ = Size(t, index)
+	if !fileExists {
+		db, err = OpenDB(backend, fn, false)
+		if err != nil {
+			return nil, err
+		}
+		// marks the graph as incomplete until generation is done
+		err = db.PutMeta(meta)
 		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
+			db.Close()
+			if backend != MEMORY {
+				os.Remove(fn)
+			}
+			return nil, err
 		}
-		return nil
-	})
+	}
 
-	var g Graph
-	if t == TYPE1 {
-		g = NewType1Graph(t, !fileExists, index, DB{db})
-	} else if t == EGS {
+	var g generator
+	if t == EGS {
 		//'index' for EGS is overloaded to be size
-		g = NewEGSGraph(t, !fileExists, index, DB{db})
-	} else if t == TYPE2 {
-		g = NewType2Graph(t, !fileExists, index, DB{db})
+		g, err = NewEGSGraph(t, false, index, seed, db)
+	} else {
+		g, err = NewType2Graph(t, false, index, seed, db)
+	}
+	if err == nil && !fileExists {
+		err = g.generate(ctx, progress)
+	}
+	if err == nil && !fileExists {
+		meta.Complete = true
+		meta.Fingerprint, err = g.Fingerprint()
+	}
+	if err == nil && !fileExists {
+		err = db.PutMeta(meta)
+	}
+	if err != nil {
+		db.Close()
+		if !fileExists && backend != MEMORY {
+			os.Remove(fn)
+		}
+		return nil, err
+	}
+
+	if fileExists || backend == MEMORY {
+		return g, nil
 	}
 
-	// a hack for testing; graph should be opened for read only after gen
+	// graph should be opened for read only after gen
 	g.Close()
-	db, err = bolt.Open(fn, 0600, &bolt.Options{ReadOnly: true})
+	db, err = OpenDB(backend, fn, true)
 	if err != nil {
-		panic("Failed to open database")
+		return nil, err
 	}
-	g.ChangeDB(DB{db})
+	g.ChangeDB(db)
 
-	return g
+	return g, nil
 }
 
-func (g *Graph_) NewNodeP(node int64, parents []int64) {
-	// header := *(*reflect.SliceHeader)(unsafe.Pointer(&parents))
-	// header.Len *= 8
-	// header.Cap *= 8
-	// data := *(*[]byte)(unsafe.Pointer(&header))
-	// log.Println("New node:", node, parents)
-
-	key := make([]byte, 8)
-	binary.PutVarint(key, node)
-	data := make([]byte, len(parents)*8)
-	for i := range parents {
-		binary.PutVarint(data[i*8:(i+1)*8], parents[i])
+// return: the file NewGraph stores the graph of type t and index in
+//         (TYPE1 graphs are never stored)
+func FileName(t int, dir string, index int64, backend int) (string, error) {
+	var fn string
+	if t == EGS {
+		fn = fmt.Sprintf("%s/EGS-%d", dir, index)
+	} else if t == TYPE2 {
+		fn = fmt.Sprintf("%s/T2-%d", dir, index)
+	} else {
+		return "", ErrUnknownType
 	}
-
-	g.db.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("Parents"))
-		err := b.Put(key, data)
-		return err
-	})
+	if backend == FLAT {
+		fn += ".flat"
+	}
+	return fn, nil
 }
 
-func (g *Graph_) NewNodeA(id int64, adjlist []int64) {
-	// header := *(*reflect.SliceHeader)(unsafe.Pointer(&parents))
-	// header.Len *= 8
-	// header.Cap *= 8
-	// data := *(*[]byte)(unsafe.Pointer(&header))
-	// log.Println("New node:", id, parents)
+// Open the graph stored at fn read only, if it was completely generated
+// with the given parameters by the current generator
+// A graph at fn whose metadata is missing, malformed, incomplete (e.g. cut
+// short by a crash) or stale is removed; errors opening or reading fn are
+// returned, and leave it alone
+// return: nil if there is no such graph
+func openComplete(backend int, fn string, t int, index int64, seed []byte) (DB, error) {
+	_, err := os.Stat(fn)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	db, err := OpenDB(backend, fn, true)
+	if err != nil && !errors.Is(err, ErrMeta) {
+		return nil, err
+	}
+	if err == nil {
+		meta, err := db.GetMeta()
+		if err != nil && !errors.Is(err, ErrMeta) {
+			db.Close()
+			return nil, err
+		}
+		if meta != nil && meta.matches(t, index, seed) {
+			return db, nil
+		}
+		db.Close()
+	}
+	os.Remove(fn)
+	return nil, nil
+}
 
-	key := make([]byte, 8)
-	binary.PutVarint(key, id)
-	data := make([]byte, len(adjlist)*8)
-	for i := range adjlist {
-		binary.PutVarint(data[i*8:(i+1)*8], adjlist[i])
+// return: number of nodes in the graph of type t and index,
+//         without generating it
+func Size(t int, index int64) (int64, error) {
+	if t == TYPE1 {
+		return numXi(index), nil
+	} else if t == EGS {
+		return index, nil
+	} else if t == TYPE2 {
+		return (int64(1) << uint64(index)) / index * index, nil
 	}
+	return 0, ErrUnknownType
+}
 
-	g.db.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("Adjlist"))
-		err := b.Put(key, data)
-		return err
-	})
+func (g *Graph_) NewNodeP(id int64, parents []int64) error {
+	return g.db.PutParents(id, parents)
 }
 
-func (g *Graph_) GetParents(id int64) []int64 {
-	// first pow2 nodes are srcs in TYPE1
-	if g.t == TYPE1 && id < int64(1<<uint64(g.index)) {
-		return nil
+// Add parents to node id during generation
+// The new parents are merged with the existing ones, and committed in
+// batches; flush must be called once generation is done
+func (g *Graph_) addParents(id int64, parents []int64) error {
+	if g.pending == nil {
+		g.pending = make(map[int64][]int64)
 	}
+	g.pending[id] = util.Union(g.pending[id], parents)
+	if len(g.pending) >= batchSize {
+		return g.flush()
+	}
+	return nil
+}
 
-	key := make([]byte, 8)
-	binary.PutVarint(key, id)
+// Commit all buffered edges, in id order, as a single batch
+func (g *Graph_) flush() error {
+	ids := make([]int64, 0, len(g.pending))
+	for id := range g.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
 
-	var data []byte
-	g.db.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("Parents"))
-		d := b.Get(key)
-		data = make([]byte, len(d))
-		copy(data, d)
-		return nil
-	})
+	parents := make([][]int64, len(ids))
+	for i, id := range ids {
+		old, err := g.db.GetParents(id)
+		if err != nil {
+			return err
+		}
+		parents[i] = util.Union(old, g.pending[id])
+	}
+	g.pending = nil
+	err := g.db.PutParentsBatch(ids, parents)
+	if err != nil {
+		return err
+	}
 
-	parents := make([]int64, len(data)/8)
+	written := int64(0)
 	for i := range parents {
-		parents[i], _ = binary.Varint(data[i*8 : (i+1)*8])
+		written += int64(len(parents[i])) * 8
 	}
-
-	return parents
+	return g.progress.Add(0, written)
 }
 
-func (g *Graph_) GetAdjacency(id int64) []int64 {
-	key := make([]byte, 8)
-	binary.PutVarint(key, id)
-
-	var data []byte
-	g.db.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("Adjlist"))
-		d := b.Get(key)
-		data = make([]byte, len(d))
-		copy(data, d)
-		return nil
-	})
+func (g *Graph_) NewNodeA(id int64, adjlist []int64) error {
+	return g.db.PutAdjacency(id, adjlist)
+}
 
-	adjlist := make([]int64, len(data)/8)
-	for i := range adjlist {
-		adjlist[i], _ = binary.Varint(data[i*8 : (i+1)*8])
-	}
+func (g *Graph_) GetParents(id int64) ([]int64, error) {
+	return g.db.GetParents(id)
+}
 
-	return adjlist
+func (g *Graph_) GetAdjacency(id int64) ([]int64, error) {
+	return g.db.GetAdjacency(id)
 }
 
 func (g *Graph_) GetSize() int64 {
@@ -211,5 +292,5 @@ func (g *Graph_) ChangeDB(db DB) {
 }
 
 func (g *Graph_) Close() {
-	g.db.db.Close()
+	g.db.Close()
 }
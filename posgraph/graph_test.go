@@ -1,7 +1,11 @@
 package posgraph
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"github.com/boltdb/bolt"
 	"github.com/kwonalbert/pospace/util"
@@ -18,9 +22,53 @@ var graphDir string = ""
 var log2 int64
 var pow2 int64
 
+func mustGraph(t int, dir string, index int64, seed []byte, backend int) Graph {
+	graph, err := NewGraph(t, dir, index, seed, backend)
+	if err != nil {
+		log.Fatal("Graph gen failed:", err)
+	}
+	return graph
+}
+
+// Parents of id in g; storage errors fail the test
+func mustParents(g Graph, id int64) []int64 {
+	parents, err := g.GetParents(id)
+	if err != nil {
+		log.Fatal("Get parents failed:", err)
+	}
+	return parents
+}
+
+func mustFingerprint(g Graph) []byte {
+	fp, err := g.Fingerprint()
+	if err != nil {
+		log.Fatal("Fingerprint failed:", err)
+	}
+	return fp
+}
+
+// Fail unless graph has the same size and parents as exp; what names
+// graph in the failure
+func sameGraph(exp, graph Graph, what ...interface{}) {
+	if graph.GetSize() != exp.GetSize() {
+		log.Fatal(append(what, " has size ", graph.GetSize(), ", not ", exp.GetSize())...)
+	}
+	for i := int64(0); i <= exp.GetSize(); i++ {
+		ps := mustParents(graph, i)
+		eps := mustParents(exp, i)
+		same := len(ps) == len(eps)
+		for j := 0; same && j < len(ps); j++ {
+			same = ps[j] == eps[j]
+		}
+		if !same {
+			log.Fatal(append(what, " differs at node ", i, ": ", ps, eps)...)
+		}
+	}
+}
+
 func TestXi(t *testing.T) {
 	now := time.Now()
-	_ = NewGraph(TYPE1, graphDir, index)
+	_ = mustGraph(TYPE1, graphDir, index, nil, BOLT)
 	log.Printf("%d. Graph gen: %fs\n", index, time.Since(now).Seconds())
 
 	// graph.GetDB().db.View(func(tx *bolt.Tx) error {
@@ -39,12 +87,113 @@ func TestXi(t *testing.T) {
 	// })
 }
 
+// Reference generator for the xi graph, after the iterative construction
+// that used to populate the database, but with 2^index sources. That one
+// made pow2 sources (pow2 being the next power of two of the whole graph)
+// and only used the last 2^index of them, so every other node id was
+// pow2-2^index higher than here, and the last nodes fell past the size.
+// TestType1Parents checks the on the fly parents against it
+func xiReference(index int64) map[int64][]int64 {
+	parents := make(map[int64][]int64)
+	count := int64(0)
+
+	butterfly := func(index int64) {
+		numLevel := 2 * index
+		perLevel := int64(1 << uint64(index))
+		begin := count - perLevel
+		for level := int64(1); level < numLevel; level++ {
+			for i := int64(0); i < perLevel; i++ {
+				shift := index - level
+				if level > numLevel/2 {
+					shift = level - numLevel/2
+				}
+				prev := i + (1 << uint64(shift))
+				if (i>>uint64(shift))&1 == 1 {
+					prev = i - (1 << uint64(shift))
+				}
+				parents[count] = []int64{begin + (level-1)*perLevel + prev,
+					count - perLevel}
+				count++
+			}
+		}
+	}
+
+	count = 1 << uint64(index) // sources
+	if index == 1 {
+		butterfly(index)
+		return parents
+	}
+
+	stack := []int64{index, index, index, index, index}
+	graphStack := []int{4, 3, 2, 1, 0}
+	for len(stack) != 0 {
+		index := stack[len(stack)-1]
+		graph := graphStack[len(graphStack)-1]
+		stack = stack[:len(stack)-1]
+		graphStack = graphStack[:len(graphStack)-1]
+
+		pow2index := int64(1 << uint64(index))
+		pow2index_1 := int64(1 << uint64(index-1))
+		if graph == 0 {
+			sources := count - pow2index
+			for i := int64(0); i < pow2index_1; i++ {
+				parents[count] = []int64{sources + i, sources + i + pow2index_1}
+				count++
+			}
+		} else if graph < 4 {
+			for i := int64(0); i < pow2index_1; i++ {
+				parents[count] = []int64{count - pow2index_1}
+				count++
+			}
+		} else {
+			sinks := count
+			sources := sinks + pow2index - numXi(index)
+			for i := int64(0); i < pow2index_1; i++ {
+				parents[sinks+i] = []int64{sinks - pow2index_1 + i, sources + i}
+				parents[sinks+i+pow2index_1] = []int64{sinks - pow2index_1 + i,
+					sources + i + pow2index_1}
+				count += 2
+			}
+		}
+
+		if (graph == 0 || graph == 3) ||
+			((graph == 1 || graph == 2) && index == 2) {
+			butterfly(index - 1)
+		} else if graph == 1 || graph == 2 {
+			stack = append(stack, index-1, index-1, index-1, index-1, index-1)
+			graphStack = append(graphStack, 4, 3, 2, 1, 0)
+		}
+	}
+	return parents
+}
+
+func TestType1Parents(t *testing.T) {
+	for idx := int64(1); idx <= 6; idx++ {
+		exp := xiReference(idx)
+		graph := mustGraph(TYPE1, graphDir, idx, nil, MEMORY)
+		if int64(len(exp))+(1<<uint64(idx)) != graph.GetSize() {
+			log.Fatal("Wrong xi size:", idx, len(exp), graph.GetSize())
+		}
+		for i := int64(0); i < graph.GetSize(); i++ {
+			ps := mustParents(graph, i)
+			if len(ps) != len(exp[i]) {
+				log.Fatal("Wrong parents for ", i, ": ", ps, exp[i])
+			}
+			for j := range ps {
+				if ps[j] != exp[i][j] {
+					log.Fatal("Wrong parents for ", i, ": ", ps, exp[i])
+				}
+			}
+		}
+	}
+}
+
 func TestEGS(t *testing.T) {
 	now := time.Now()
-	graph := NewGraph(EGS, graphDir, index)
+	graph := mustGraph(EGS, graphDir, index, []byte("seed"), BOLT)
 	log.Printf("%d. Graph gen: %fs\n", index, time.Since(now).Seconds())
 
-	graph.GetDB().db.View(func(tx *bolt.Tx) error {
+	graph.GetDB().(*boltDB).db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("Adjlist"))
 		c := b.Cursor()
 
@@ -61,6 +210,266 @@ func TestEGS(t *testing.T) {
 	})
 }
 
+func TestDeterministic(t *testing.T) {
+	seed := []byte("public seed")
+	for _, typ := range []int{EGS, TYPE2} {
+		dir1, _ := os.MkdirTemp("", "pospace")
+		dir2, _ := os.MkdirTemp("", "pospace")
+		g1 := mustGraph(typ, dir1, 6, seed, BOLT)
+		g2 := mustGraph(typ, dir2, 6, seed, BOLT)
+		sameGraph(g1, g2, "Graph from the same seed")
+		g1.Close()
+		g2.Close()
+		os.RemoveAll(dir1)
+		os.RemoveAll(dir2)
+	}
+}
+
+func TestOpenError(t *testing.T) {
+	_, err := NewGraph(EGS, "/nonexistent/dir", index, nil, BOLT)
+	if !errors.Is(err, ErrOpenDB) {
+		log.Fatal("Expected ErrOpenDB:", err)
+	}
+	_, err = NewGraph(EGS, graphDir, index, nil, -1)
+	if !errors.Is(err, ErrUnknownBackend) {
+		log.Fatal("Expected ErrUnknownBackend:", err)
+	}
+
+	// storage errors are returned, not read as missing parents
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	graph := mustGraph(EGS, dir, index, nil, BOLT)
+	graph.Close()
+	if _, err := graph.GetParents(1); err == nil {
+		log.Fatal("Read parents from a closed graph")
+	}
+	if _, err := fingerprint(graph); err == nil {
+		log.Fatal("Fingerprinted a closed graph")
+	}
+}
+
+func TestBackends(t *testing.T) {
+	seed := []byte("public seed")
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+
+	exp := mustGraph(TYPE2, dir, 6, seed, BOLT)
+	defer exp.Close()
+	for _, backend := range []int{MEMORY, FLAT} {
+		graph := mustGraph(TYPE2, dir, 6, seed, backend)
+		sameGraph(exp, graph, "Backend ", backend)
+		graph.Close()
+	}
+
+	// reopening the flat file should give the same graph
+	graph := mustGraph(TYPE2, dir, 6, seed, FLAT)
+	defer graph.Close()
+	sameGraph(exp, graph, "Reopened flat graph")
+}
+
+func TestBatched(t *testing.T) {
+	seed := []byte("public seed")
+	dir1, _ := os.MkdirTemp("", "pospace")
+	dir2, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir1)
+	defer os.RemoveAll(dir2)
+
+	exp := mustGraph(TYPE2, dir1, 6, seed, BOLT)
+	defer exp.Close()
+
+	defer func(size int) { batchSize = size }(batchSize)
+	batchSize = 7 // force many partial batches
+	graph := mustGraph(TYPE2, dir2, 6, seed, BOLT)
+	defer graph.Close()
+	sameGraph(exp, graph, "Batched graph")
+}
+
+func TestMeta(t *testing.T) {
+	seed := []byte("public seed")
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+
+	exp := mustGraph(TYPE2, dir, 6, seed, MEMORY)
+	defer exp.Close()
+	for _, backend := range []int{BOLT, FLAT} {
+		fn := dir + "/T2-6"
+		if backend == FLAT {
+			fn += ".flat"
+		}
+		graph := mustGraph(TYPE2, dir, 6, seed, backend)
+		graph.Close()
+
+		// cut generation short after the edges were written
+		if backend == BOLT {
+			db, err := OpenDB(BOLT, fn, false)
+			if err != nil {
+				log.Fatal("Open failed:", err)
+			}
+			db.PutMeta(&Meta{Type: TYPE2, Index: 6, Seed: seed, Version: GeneratorVersion})
+			db.Close()
+		} else {
+			info, _ := os.Stat(fn)
+			os.Truncate(fn, info.Size()-8)
+		}
+
+		graph = mustGraph(TYPE2, dir, 6, seed, backend)
+		meta, err := graph.GetDB().GetMeta()
+		if err != nil || meta == nil || !meta.matches(TYPE2, 6, seed) {
+			log.Fatal("Partial graph was not regenerated: ", meta, err)
+		}
+		if meta.Size != exp.GetSize() {
+			log.Fatal("Wrong size in metadata: ", meta.Size)
+		}
+		sameGraph(exp, graph, "Regenerated graph")
+		graph.Close()
+
+		// so is a flat file cut short before its header
+		if backend == FLAT {
+			os.Truncate(fn, 4)
+			graph = mustGraph(TYPE2, dir, 6, seed, backend)
+			meta, err = graph.GetDB().GetMeta()
+			if err != nil || meta == nil || !meta.matches(TYPE2, 6, seed) {
+				log.Fatal("Truncated graph was not regenerated: ", meta, err)
+			}
+			graph.Close()
+		}
+
+		// a graph from another seed is stale as well
+		graph = mustGraph(TYPE2, dir, 6, []byte("other seed"), backend)
+		meta, err = graph.GetDB().GetMeta()
+		if err != nil || meta == nil || !meta.matches(TYPE2, 6, []byte("other seed")) {
+			log.Fatal("Graph was not regenerated for a new seed: ", meta, err)
+		}
+		graph.Close()
+	}
+}
+
+// Graph files that cannot be opened are reported, not regenerated
+func TestMetaOpenError(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+
+	for _, backend := range []int{BOLT, FLAT} {
+		fn, _ := FileName(TYPE2, dir, 6, backend)
+		os.Mkdir(fn, 0700)
+		_, err := NewGraph(TYPE2, dir, 6, nil, backend)
+		if !errors.Is(err, ErrOpenDB) {
+			log.Fatal("Expected ErrOpenDB:", err)
+		}
+		if _, err := os.Stat(fn); err != nil {
+			log.Fatal("Unreadable graph file was removed:", err)
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	seed := []byte("public seed")
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+
+	exp := mustGraph(TYPE2, dir, 6, seed, MEMORY)
+	defer exp.Close()
+	for _, backend := range []int{BOLT, FLAT} {
+		// once from generation, once from the stored metadata
+		for i := 0; i < 2; i++ {
+			graph := mustGraph(TYPE2, dir, 6, seed, backend)
+			if !bytes.Equal(mustFingerprint(graph), mustFingerprint(exp)) {
+				log.Fatal("Backend ", backend, " changed the fingerprint")
+			}
+			meta, _ := graph.GetDB().GetMeta()
+			if meta == nil || !bytes.Equal(meta.Fingerprint, mustFingerprint(exp)) {
+				log.Fatal("Fingerprint missing from the metadata")
+			}
+			graph.Close()
+		}
+	}
+
+	other := mustGraph(TYPE2, dir, 6, []byte("other seed"), MEMORY)
+	if bytes.Equal(mustFingerprint(other), mustFingerprint(exp)) {
+		log.Fatal("Different seeds gave the same fingerprint")
+	}
+	xi3 := mustGraph(TYPE1, dir, 3, nil, MEMORY)
+	xi4 := mustGraph(TYPE1, dir, 4, nil, MEMORY)
+	if bytes.Equal(mustFingerprint(xi3), mustFingerprint(xi4)) {
+		log.Fatal("Different indices gave the same fingerprint")
+	}
+
+	// Type1 fingerprints do not walk the graph, so pin its parents here:
+	// if this fails, GetParents changed and Type1Version must be bumped
+	// (then update the hash)
+	walked, err := fingerprint(xi4)
+	if err != nil || hex.EncodeToString(walked) !=
+		"2d4a078107af31bfc3ab9394ab48231367d1b0b6171c10a4fb5de7827cc54e2d" {
+		log.Fatalf("Type1 parents changed (%x, %v); bump Type1Version", walked, err)
+	}
+
+	// without walking its nodes, or this would take minutes
+	xi20 := mustGraph(TYPE1, dir, 20, nil, MEMORY)
+	if len(mustFingerprint(xi20)) == 0 || bytes.Equal(mustFingerprint(xi20), mustFingerprint(xi4)) {
+		log.Fatal("Bad fingerprint for index 20")
+	}
+}
+
+func TestGraphContext(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := NewGraphContext(ctx, TYPE2, dir, 6, nil, BOLT, nil)
+	if !errors.Is(err, context.Canceled) {
+		log.Fatal("Cancelled generation did not stop:", err)
+	}
+	if _, err := os.Stat(dir + "/T2-6"); err == nil {
+		log.Fatal("Cancelled generation left a graph behind")
+	}
+
+	for _, typ := range []int{EGS, TYPE2} {
+		var last util.Progress
+		graph, err := NewGraphContext(context.Background(), typ, dir, 6, nil, BOLT,
+			func(p util.Progress) { last = p })
+		if err != nil {
+			log.Fatal("Graph gen failed:", err)
+		}
+		graph.Close()
+		if last.Total == 0 || last.Done != last.Total || last.Bytes == 0 {
+			log.Fatal("Generation did not report completion:", last)
+		}
+	}
+
+	// a cancelled in memory graph leaves the stored graph alone
+	_, err = NewGraphContext(ctx, TYPE2, dir, 6, nil, MEMORY, nil)
+	if !errors.Is(err, context.Canceled) {
+		log.Fatal("Cancelled generation did not stop:", err)
+	}
+	if _, err := os.Stat(dir + "/T2-6"); err != nil {
+		log.Fatal("Cancelled in memory generation removed the stored graph:", err)
+	}
+}
+
+func benchmarkGen(b *testing.B, batch int) {
+	defer func(size int) { batchSize = size }(batchSize)
+	batchSize = batch
+	for i := 0; i < b.N; i++ {
+		dir, _ := os.MkdirTemp("", "pospace")
+		graph := mustGraph(TYPE2, dir, 6, []byte("public seed"), BOLT)
+		graph.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// Batch size 1 commits every edge in its own transaction, which is how
+// graphs used to be generated. For the index 6 Type2 graph (600 edges):
+//   BenchmarkGenUnbatched    ~100ms/op
+//   BenchmarkGenBatched      ~2.8ms/op
+func BenchmarkGenUnbatched(b *testing.B) {
+	benchmarkGen(b, 1)
+}
+
+func BenchmarkGenBatched(b *testing.B) {
+	benchmarkGen(b, 1<<16)
+}
+
 func TestMain(m *testing.M) {
 	size = numXi(index)
 	log2 = util.Log2(size) + 1
@@ -0,0 +1,66 @@
+package posgraph
+
+// In-memory storage; nothing survives Close
+type memDB struct {
+	parents map[int64][]int64
+	adjlist map[int64][]int64
+	meta    *Meta
+}
+
+func newMemDB() *memDB {
+	return &memDB{
+		parents: make(map[int64][]int64),
+		adjlist: make(map[int64][]int64),
+	}
+}
+
+func copyList(list []int64) []int64 {
+	res := make([]int64, len(list))
+	copy(res, list)
+	return res
+}
+
+func (db *memDB) GetParents(id int64) ([]int64, error) {
+	return copyList(db.parents[id]), nil
+}
+
+func (db *memDB) PutParents(id int64, parents []int64) error {
+	db.parents[id] = copyList(parents)
+	return nil
+}
+
+func (db *memDB) PutParentsBatch(ids []int64, parents [][]int64) error {
+	for i := range ids {
+		db.parents[ids[i]] = copyList(parents[i])
+	}
+	return nil
+}
+
+func (db *memDB) GetAdjacency(id int64) ([]int64, error) {
+	return copyList(db.adjlist[id]), nil
+}
+
+func (db *memDB) PutAdjacency(id int64, adjlist []int64) error {
+	db.adjlist[id] = copyList(adjlist)
+	return nil
+}
+
+func (db *memDB) GetMeta() (*Meta, error) {
+	if db.meta == nil {
+		return nil, nil
+	}
+	meta := *db.meta
+	return &meta, nil
+}
+
+func (db *memDB) PutMeta(meta *Meta) error {
+	m := *meta
+	m.Seed = append([]byte{}, meta.Seed...)
+	m.Fingerprint = append([]byte{}, meta.Fingerprint...)
+	db.meta = &m
+	return nil
+}
+
+func (db *memDB) Close() error {
+	return nil
+}
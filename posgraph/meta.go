@@ -0,0 +1,72 @@
+package posgraph
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Version of the edge generation; bump it whenever a change to the
+// generators changes the edges, so stale graph files get regenerated
+const GeneratorVersion = 1
+
+var ErrMeta = errors.New("posgraph: malformed graph metadata")
+
+// Description of a stored graph, kept alongside its edges
+type Meta struct {
+	Type     int
+	Index    int64
+	Size     int64
+	Seed     []byte
+	Version  int  // GeneratorVersion the graph was generated with
+	Complete bool // set once generation has finished
+
+	Fingerprint []byte // see Graph.Fingerprint; set with Complete
+}
+
+func (m *Meta) fields() []int64 {
+	complete := int64(0)
+	if m.Complete {
+		complete = 1
+	}
+	return []int64{int64(m.Type), m.Index, m.Size, int64(m.Version), complete}
+}
+
+func (m *Meta) setFields(fields []int64) {
+	m.Type = int(fields[0])
+	m.Index = fields[1]
+	m.Size = fields[2]
+	m.Version = int(fields[3])
+	m.Complete = fields[4] == 1
+}
+
+// return: whether m describes a finished graph with the given parameters,
+//         generated by the current generator
+func (m *Meta) matches(t int, index int64, seed []byte) bool {
+	return m.Complete && m.Version == GeneratorVersion &&
+		m.Type == t && m.Index == index && bytes.Equal(m.Seed, seed)
+}
+
+func (m *Meta) marshal() []byte {
+	data := encodeList(append(m.fields(), int64(len(m.Seed)), int64(len(m.Fingerprint))))
+	data = append(data, m.Seed...)
+	return append(data, m.Fingerprint...)
+}
+
+func unmarshalMeta(data []byte) (*Meta, error) {
+	n := len((&Meta{}).fields()) + 2
+	if len(data) < n*8 {
+		return nil, ErrMeta
+	}
+	fields := decodeList(data[:n*8])
+	seedLen, fpLen := fields[n-2], fields[n-1]
+	data = data[n*8:]
+	if seedLen < 0 || fpLen < 0 || seedLen+fpLen != int64(len(data)) {
+		return nil, ErrMeta
+	}
+	m := &Meta{
+		Seed:        append([]byte{}, data[:seedLen]...),
+		Fingerprint: append([]byte{}, data[seedLen:]...),
+	}
+	m.setFields(fields)
+	return m, nil
+}
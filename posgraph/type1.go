@@ -5,11 +5,17 @@ import (
 	//"log"
 )
 
+// Version of the Type1 node layout; bump it whenever GetParents changes,
+// so Type1 fingerprints change with it
+const Type1Version = 1
+
+// Type1 graphs are fully structural, so parents are computed from the
+// index on demand instead of being generated and stored
 type Type1Graph struct {
 	Graph_
 }
 
-func NewType1Graph(t int, gen bool, index int64, db DB) *Type1Graph {
+func NewType1Graph(t int, index int64) *Type1Graph {
 	g := &Type1Graph{
 		Graph_{
 			index: index,
@@ -29,11 +35,6 @@ func NewType1Graph(t int, gen bool, index int64, db DB) *Type1Graph {
 	g.size = size
 	g.pow2 = pow2
 	g.log2 = log2
-	g.db = db
-
-	if gen {
-		g.Type1Graph()
-	}
 
 	return g
 }
@@ -42,129 +43,89 @@ func numXi(index int64) int64 {
 	return (1 << uint64(index)) * (index + 1) * index
 }
 
-func (g *Type1Graph) butterflyGraph(index int64, count *int64) {
-	if index == 0 {
-		index = 1
-	}
-	numLevel := 2 * index
+// number of nodes a butterfly graph adds on top of its level 0
+func numButterfly(index int64) int64 {
+	return (2*index - 1) * (1 << uint64(index))
+}
+
+// Parents of node id in the butterfly graph of index whose level 0
+// starts at begin
+func butterflyParents(index, begin, id int64) []int64 {
 	perLevel := int64(1 << uint64(index))
-	begin := *count - perLevel // level 0 created outside
-	// no parents at level 0
-	var level, i int64
-	for level = 1; level < numLevel; level++ {
-		for i = 0; i < perLevel; i++ {
-			var prev int64
-			shift := index - level
-			if level > numLevel/2 {
-				shift = level - numLevel/2
-			}
-			if (i>>uint64(shift))&1 == 0 {
-				prev = i + (1 << uint64(shift))
-			} else {
-				prev = i - (1 << uint64(shift))
-			}
-			parents := []int64{begin + (level-1)*perLevel + prev,
-				*count - perLevel}
+	level := (id - begin) / perLevel
+	i := (id - begin) % perLevel
 
-			g.NewNodeP(*count, parents)
-			*count++
-		}
+	shift := index - level
+	if level > index {
+		shift = level - index
 	}
-}
+	prev := i ^ (1 << uint64(shift))
 
-// Iterative generation of the graph
-// (exponential recursive calls take too long)
-func (g *Type1Graph) Type1Graph() {
-	count := int64(0)
+	return []int64{begin + (level-1)*perLevel + prev, id - perLevel}
+}
 
-	stack := []int64{g.index, g.index, g.index, g.index, g.index}
-	graphStack := []int{4, 3, 2, 1, 0}
+// Nodes of the xi graph of index n are laid out (in topological order) as
+//   sources (2^n), connector + butterfly(n-1), xi(n-1), xi(n-1),
+//   connector + butterfly(n-1), sinks (2^n)
+// where the sources of each sub graph are the connector nodes in front of
+// it. GetParents walks down this recursion, so each call takes O(index).
+// The parents are computed, so there is never an error
+func (g *Type1Graph) GetParents(id int64) ([]int64, error) {
+	return g.parents(id), nil
+}
 
-	var i int64
-	graph := 0
-	for i = 0; i < g.pow2; i++ { //sources at this level
-		g.NewNodeP(count, nil)
-		count++
+func (g *Type1Graph) parents(id int64) []int64 {
+	if id < 0 || id >= g.size {
+		return nil
 	}
 
-	if g.index == 1 {
-		g.butterflyGraph(g.index, &count)
-		return
+	index := g.index
+	base := int64(0) // first source of the current xi graph
+	if id < (1 << uint64(index)) {
+		return nil
 	}
 
-	var index int64
-	for len(stack) != 0 && len(graphStack) != 0 {
-		index, stack = stack[len(stack)-1], stack[:len(stack)-1]
-		graph, graphStack = graphStack[len(graphStack)-1], graphStack[:len(graphStack)-1]
-
-		indices := []int64{index - 1, index - 1, index - 1, index - 1, index - 1}
-		graphs := []int{4, 3, 2, 1, 0}
-
-		pow2index := int64(1 << uint64(index))
-		pow2index_1 := int64(1 << uint64(index-1))
-
-		if graph == 0 {
-			sources := count - pow2index
-			// sources to sources of first butterfly
-			// create sources of first butterly
-			for i = 0; i < pow2index_1; i++ {
-				parents := []int64{sources + i,
-					sources + i + pow2index_1}
+	for {
+		if index == 1 {
+			return butterflyParents(1, base, id)
+		}
 
-				g.NewNodeP(count, parents)
-				count++
-			}
-		} else if graph == 1 {
-			firstXi := count
-			// sinks of first butterfly to sources of first xi graph
-			for i = 0; i < pow2index_1; i++ {
-				nodeId := firstXi + i
-				parents := []int64{firstXi - pow2index_1 + i}
-				g.NewNodeP(nodeId, parents)
-				count++
-			}
-		} else if graph == 2 {
-			secondXi := count
-			// sinks of first xi to sources of second xi
-			for i = 0; i < pow2index_1; i++ {
-				nodeId := secondXi + i
-				parents := []int64{secondXi - pow2index_1 + i}
-				g.NewNodeP(nodeId, parents)
-				count++
+		half := int64(1 << uint64(index-1))
+		butterfly := numButterfly(index - 1)
+		subXi := numXi(index - 1)
+
+		first := base + 2*half
+		xi1 := first + half + butterfly
+		xi2 := xi1 + subXi
+		second := xi2 + subXi
+		sinks := second + half + butterfly
+
+		if id < first+half { // sources to sources of first butterfly
+			i := id - first
+			return []int64{base + i, base + i + half}
+		} else if id < xi1 {
+			return butterflyParents(index-1, first, id)
+		} else if id < second {
+			sub := xi1
+			if id >= xi2 {
+				sub = xi2
 			}
-		} else if graph == 3 {
-			secondButter := count
-			// sinks of second xi to sources of second butterfly
-			for i = 0; i < pow2index_1; i++ {
-				nodeId := secondButter + i
-				parents := []int64{secondButter - pow2index_1 + i}
-				g.NewNodeP(nodeId, parents)
-				count++
+			if id < sub+half { // sinks of the previous graph to sources
+				return []int64{id - half}
 			}
+			base = sub
+			index--
+		} else if id < second+half { // sinks of second xi to sources
+			return []int64{id - half}
+		} else if id < sinks {
+			return butterflyParents(index-1, second, id)
 		} else {
-			sinks := count
-			sources := sinks + pow2index - numXi(index)
-			for i = 0; i < pow2index_1; i++ {
-				nodeId0 := sinks + i
-				nodeId1 := sinks + i + pow2index_1
-
-				parents0 := []int64{sinks - pow2index_1 + i,
-					sources + i}
-				parents1 := []int64{sinks - pow2index_1 + i,
-					sources + i + pow2index_1}
-
-				g.NewNodeP(nodeId0, parents0[:])
-				g.NewNodeP(nodeId1, parents1[:])
-				count += 2
-			}
-		}
-
-		if (graph == 0 || graph == 3) ||
-			((graph == 1 || graph == 2) && index == 2) {
-			g.butterflyGraph(index-1, &count)
-		} else if graph == 1 || graph == 2 {
-			stack = append(stack, indices...)
-			graphStack = append(graphStack, graphs...)
+			j := id - sinks
+			return []int64{sinks - half + j%half, base + j}
 		}
 	}
 }
+
+// Nothing to close; the graph is never stored
+func (g *Type1Graph) Close() {
+}
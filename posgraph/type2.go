@@ -1,6 +1,7 @@
 package posgraph
 
 import (
+	"context"
 	"github.com/kwonalbert/pospace/util"
 	//"log"
 )
@@ -11,7 +12,7 @@ type Type2Graph struct {
 	m int64
 }
 
-func NewType2Graph(t int, gen bool, index int64, db DB) *Type2Graph {
+func NewType2Graph(t int, gen bool, index int64, seed []byte, db DB) (*Type2Graph, error) {
 	indexpow2 := int64(1 << uint64(index))
 	//TODO: get the correct constant here
 	m := indexpow2 / index
@@ -19,6 +20,7 @@ func NewType2Graph(t int, gen bool, index int64, db DB) *Type2Graph {
 		Graph_{
 			index: index,
 			size:  m * index,
+			seed:  seed,
 			t:     TYPE2,
 		},
 		m,
@@ -37,31 +39,81 @@ func NewType2Graph(t int, gen bool, index int64, db DB) *Type2Graph {
 	g.db = db
 
 	if gen {
-		g.Type2Graph()
+		err := g.Type2Graph()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return g
+	return g, nil
 }
 
-func (g *Type2Graph) Type2Graph() {
-	egs := NewGraph(EGS, "/tmp", g.index)
+func (g *Type2Graph) generate(ctx context.Context, fn util.ProgressFunc) error {
+	egs, err := g.egs()
+	if err != nil {
+		return err
+	}
+	total := int64(0)
+	for i := int64(0); i < g.index; i++ {
+		parents, err := egs.GetParents(i)
+		if err != nil {
+			return err
+		}
+		total += int64(len(parents)) * g.m
+	}
+	g.progress = util.NewTracker(ctx, total, fn)
+	defer func() { g.progress = nil }()
+	err = g.Type2Graph()
+	if err != nil {
+		return err
+	}
+	g.progress.Finish()
+	return nil
+}
+
+// the underlying EGS graph only has index nodes
+func (g *Type2Graph) egs() (Graph, error) {
+	return NewGraph(EGS, "", g.index, g.seed, MEMORY)
+}
+
+func (g *Type2Graph) Type2Graph() error {
+	egs, err := g.egs()
+	if err != nil {
+		return err
+	}
 
 	for i := int64(0); i < g.index; i++ {
-		parents := egs.GetParents(i)
+		parents, err := egs.GetParents(i)
+		if err != nil {
+			return err
+		}
 		for _, p := range parents {
-			g.bipartiteGraph(i*g.m, p*g.m)
+			err = g.bipartiteGraph(i*g.m, p*g.m)
+			if err != nil {
+				return err
+			}
 		}
 	}
+	return g.flush()
 }
 
 // Generate random bipartite graph betwene srcs and sinks
-func (g *Type2Graph) bipartiteGraph(src_start, sink_start int64) {
+// The edges of source s are drawn from util.NewPRNG(seed, sink_start, s)
+func (g *Type2Graph) bipartiteGraph(src_start, sink_start int64) error {
 	for s := src_start; s < src_start+g.m; s++ {
-		numEdges := util.Rand(g.m)
-		vals := util.NRandRange(sink_start, sink_start+g.m, numEdges)
+		prng := util.NewPRNG(g.seed, sink_start, s)
+		numEdges := prng.Rand(g.m)
+		vals := prng.NRandRange(sink_start, sink_start+g.m, numEdges)
 		for _, t := range vals {
-			parents := util.Union(g.GetParents(t), []int64{s})
-			g.NewNodeP(t, parents)
+			err := g.addParents(t, []int64{s})
+			if err != nil {
+				return err
+			}
+		}
+		err := g.progress.Add(1, 0)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
 }
@@ -4,7 +4,10 @@ import (
 	"crypto/rand"
 	"flag"
 	"fmt"
+	"github.com/kwonalbert/pospace/params"
+	"github.com/kwonalbert/pospace/proof"
 	"github.com/kwonalbert/pospace/prover"
+	"github.com/kwonalbert/pospace/util"
 	"github.com/kwonalbert/pospace/verifier"
 	"log"
 	"os"
@@ -26,14 +29,114 @@ func TestPoS(t *testing.T) {
 	rand.Read(seed)
 	challenges := v.SelectChallenges(seed)
 	now := time.Now()
-	hashes, parents, proofs, pProofs := p.ProveSpace(challenges)
+	pf, err := p.ProveSpace(challenges)
+	if err != nil {
+		log.Fatal("Prove space failed:", err)
+	}
 	fmt.Printf("Prove: %f\n", time.Since(now).Seconds())
 
+	// send the proof over the wire
+	data, err := pf.MarshalBinary()
+	if err != nil {
+		log.Fatal("Marshal failed:", err)
+	}
+	pf = new(proof.Proof)
+	if err = pf.UnmarshalBinary(data); err != nil {
+		log.Fatal("Unmarshal failed:", err)
+	}
+
 	now = time.Now()
-	if !v.VerifySpace(challenges, hashes, parents, proofs, pProofs) {
+	if !v.VerifySpace(challenges, pf) {
 		log.Fatal("Verify space failed:", challenges)
 	}
 	fmt.Printf("Verify: %f\n", time.Since(now).Seconds())
+
+	pf.Hashes[0][0] ^= 1
+	if v.VerifySpace(challenges, pf) {
+		log.Fatal("Verify space accepted a tampered proof")
+	}
+}
+
+func TestNonInteractive(t *testing.T) {
+	epoch := []byte("epoch 1")
+	np, err := p.ProveSpaceNI(epoch, beta)
+	if err != nil {
+		log.Fatal("Prove space failed:", err)
+	}
+
+	// a third party only needs the commitment and the proof
+	data, err := np.MarshalBinary()
+	if err != nil {
+		log.Fatal("Marshal failed:", err)
+	}
+	np = new(proof.NIProof)
+	if err = np.UnmarshalBinary(data); err != nil {
+		log.Fatal("Unmarshal failed:", err)
+	}
+	if !v.VerifyNI(epoch, np) {
+		log.Fatal("Verify non-interactive proof failed")
+	}
+	if v.VerifyNI([]byte("epoch 2"), np) {
+		log.Fatal("Verify accepted a proof for another epoch")
+	}
+}
+
+func TestHashes(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	for _, hash := range []int{util.SHA3, util.SHA256, util.BLAKE2B, util.ARGON2} {
+		p, err := prover.NewProver(pk, index, hash, graphDir, dir)
+		if err != nil {
+			log.Fatal("New prover failed:", err)
+		}
+		commit, err := p.Init()
+		if err != nil {
+			log.Fatal("Init failed:", err)
+		}
+		v, err := verifier.NewVerifier(pk, index, commit.Hash, beta, commit.Commit, graphDir)
+		if err != nil {
+			log.Fatal("New verifier failed:", err)
+		}
+
+		seed := make([]byte, 64)
+		rand.Read(seed)
+		challenges := v.SelectChallenges(seed)
+		pf, err := p.ProveSpace(challenges)
+		if err != nil {
+			log.Fatal("Prove space failed:", err)
+		}
+		if !v.VerifySpace(challenges, pf) {
+			log.Fatal("Verify space failed with hash ", hash)
+		}
+	}
+}
+
+func TestSoundness(t *testing.T) {
+	commit, err := p.PreInit()
+	if err != nil {
+		log.Fatal("Pre init failed:", err)
+	}
+	v, err := verifier.NewVerifierSoundness(pk, index, commit.Hash, 0.1, 1e-6, commit.Commit, graphDir)
+	if err != nil {
+		log.Fatal("New verifier failed:", err)
+	}
+	n, _ := params.MinChallenges(0.1, 1e-6)
+	challenges := v.SelectChallenges([]byte("seed"))
+	if int64(len(challenges)) < n {
+		log.Fatal("Too few challenges for the soundness: ", len(challenges), n)
+	}
+	pf, err := p.ProveSpace(challenges)
+	if err != nil {
+		log.Fatal("Prove space failed:", err)
+	}
+	if !v.VerifySpace(challenges, pf) {
+		log.Fatal("Verify space failed")
+	}
+
+	_, err = verifier.NewVerifierSoundness(pk, index, commit.Hash, 0, 1e-6, commit.Commit, graphDir)
+	if err != params.ErrParams {
+		log.Fatal("Accepted discard of 0:", err)
+	}
 }
 
 func TestMain(m *testing.M) {
@@ -45,14 +148,27 @@ func TestMain(m *testing.M) {
 	flag.Parse()
 	index = int64(*id)
 
-	p = prover.NewProver(pk, index, graphDir, ".")
+	var err error
+	p, err = prover.NewProver(pk, index, util.SHA3, graphDir, ".")
+	if err != nil {
+		log.Fatal("New prover failed:", err)
+	}
 
 	now := time.Now()
-	commit := p.Init()
+	commit, err := p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
 	fmt.Printf("%d. Graph commit: %fs\n", index, time.Since(now).Seconds())
 
 	root := commit.Commit
-	v = verifier.NewVerifier(pk, index, beta, root, graphDir)
+	v, err = verifier.NewVerifier(pk, index, commit.Hash, beta, root, graphDir)
+	if err != nil {
+		log.Fatal("New verifier failed:", err)
+	}
+	if !v.VerifyGraph(commit.Graph) {
+		log.Fatal("Prover and verifier have different graphs")
+	}
 
 	os.Exit(m.Run())
 }
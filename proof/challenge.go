@@ -0,0 +1,142 @@
+package proof
+
+import (
+	"errors"
+	"github.com/kwonalbert/pospace/util"
+	"golang.org/x/crypto/sha3"
+)
+
+var ErrSampling = errors.New("proof: invalid challenge sampling")
+
+// A range of nodes [Start, End) sampled with relative Weight
+type Range struct {
+	Start, End int64
+	Weight     int64
+}
+
+// How challenges are drawn from the nodes of a graph
+// The zero value draws uniformly from all nodes, with replacement
+type Sampling struct {
+	Distinct bool    // sample without replacement
+	Ranges   []Range // e.g. only the sinks, or the last layers more often
+}
+
+// Check the sampling can draw n challenges among size nodes
+func (s Sampling) Validate(n int, size int64) error {
+	total := int64(0)
+	for i, r := range s.Ranges {
+		if r.Start < 0 || r.End > size || r.Start >= r.End || r.Weight <= 0 {
+			return ErrSampling
+		}
+		for _, o := range s.Ranges[:i] {
+			if r.Start < o.End && o.Start < r.End {
+				return ErrSampling
+			}
+		}
+		total += r.End - r.Start
+	}
+	if s.Ranges == nil {
+		total = size
+	}
+	if size <= 0 || (s.Distinct && int64(n) > total) {
+		return ErrSampling
+	}
+	return nil
+}
+
+// Draw n challenges among size nodes from seed
+// The randomness is the SHAKE256 stream of util.NewPRNG(seed); every
+// value is drawn by rejection sampling, so there is no modulo bias. With
+// ranges (which must not overlap), a range is drawn by weight, then a
+// node uniformly inside it.
+func (s Sampling) Sample(seed []byte, n int, size int64) ([]int64, error) {
+	if err := s.Validate(n, size); err != nil {
+		return nil, err
+	}
+	ranges := s.Ranges
+	if ranges == nil {
+		ranges = []Range{{0, size, 1}}
+	}
+	total := int64(0)
+	for _, r := range ranges {
+		total += r.Weight
+	}
+
+	prng := util.NewPRNG(seed)
+	seen := make(map[int64]bool)
+	challenges := make([]int64, 0, n)
+	for len(challenges) < n {
+		w := prng.Rand(total)
+		r := ranges[0]
+		for _, r = range ranges {
+			if w < r.Weight {
+				break
+			}
+			w -= r.Weight
+		}
+		c := r.Start + prng.Rand(r.End-r.Start)
+		if s.Distinct {
+			if seen[c] {
+				continue
+			}
+			seen[c] = true
+		}
+		challenges = append(challenges, c)
+	}
+	return challenges, nil
+}
+
+// Derive n challenges uniformly among size nodes from seed
+// Shared by the verifier (interactive seeds) and the prover (Fiat-Shamir)
+func Challenges(seed []byte, n int, size int64) []int64 {
+	challenges, _ := Sampling{}.Sample(seed, n, size)
+	return challenges
+}
+
+// Seed for non-interactive challenges:
+// SHA3-256 over the length prefixed pk, commitment root and epoch, where
+// epoch is public randomness the prover cannot choose (e.g. a beacon)
+func FiatShamirSeed(pk, commit, epoch []byte) []byte {
+	e := &util.Encoder{}
+	e.Bytes([]byte("pospace fiat-shamir"))
+	e.Bytes(pk)
+	e.Bytes(commit)
+	e.Bytes(epoch)
+	seed := sha3.Sum256(e.Data())
+	return seed[:]
+}
+
+// Encoding of a list of challenges (same conventions as Proof):
+//   version          uint16, ChallengesVersion
+//   #challenges      uint32, then int64 each
+const ChallengesVersion = 1
+
+func MarshalChallenges(challenges []int64) []byte {
+	e := &util.Encoder{}
+	e.Uint16(ChallengesVersion)
+	e.Uint32(len(challenges))
+	for _, c := range challenges {
+		e.Int64(c)
+	}
+	return e.Data()
+}
+
+func UnmarshalChallenges(data []byte) ([]int64, error) {
+	d := util.NewDecoder(data, ErrMalformed)
+	version := d.Uint16()
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+	if version != ChallengesVersion {
+		return nil, ErrVersion
+	}
+
+	challenges := make([]int64, d.Count(8))
+	for i := range challenges {
+		challenges[i] = d.Int64()
+	}
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+	return challenges, nil
+}
@@ -0,0 +1,52 @@
+package proof
+
+import (
+	"log"
+	"testing"
+)
+
+func checkVector(name string, res, exp []int64) {
+	if len(res) != len(exp) {
+		log.Fatal(name, " changed: ", res, exp)
+	}
+	for i := range exp {
+		if res[i] != exp[i] {
+			log.Fatal(name, " changed: ", res, exp)
+		}
+	}
+}
+
+// Pin the challenges for a fixed seed; the prover and verifier must keep
+// deriving the same ones
+func TestChallengeVectors(t *testing.T) {
+	seed := []byte("seed")
+	checkVector("Uniform", Challenges(seed, 8, 1000),
+		[]int64{42, 130, 390, 369, 698, 667, 928, 56})
+
+	res, err := Sampling{Distinct: true}.Sample(seed, 10, 10)
+	if err != nil {
+		log.Fatal("Distinct sampling failed:", err)
+	}
+	checkVector("Distinct", res, []int64{2, 0, 9, 8, 7, 6, 3, 5, 4, 1})
+
+	weighted := Sampling{Ranges: []Range{{0, 100, 1}, {900, 1000, 3}}}
+	res, err = weighted.Sample(seed, 8, 1000)
+	if err != nil {
+		log.Fatal("Weighted sampling failed:", err)
+	}
+	checkVector("Weighted", res, []int64{942, 930, 990, 69, 998, 67, 928, 956})
+}
+
+func TestSamplingErrors(t *testing.T) {
+	if (Sampling{Distinct: true}).Validate(11, 10) != ErrSampling {
+		log.Fatal("Accepted more distinct challenges than nodes")
+	}
+	overlap := Sampling{Ranges: []Range{{0, 10, 1}, {5, 15, 1}}}
+	if overlap.Validate(1, 20) != ErrSampling {
+		log.Fatal("Accepted overlapping ranges")
+	}
+	outside := Sampling{Ranges: []Range{{10, 30, 1}}}
+	if outside.Validate(1, 20) != ErrSampling {
+		log.Fatal("Accepted range outside the graph")
+	}
+}
@@ -0,0 +1,103 @@
+package proof
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+)
+
+var ErrMultiproof = errors.New("proof: inconsistent multiproof")
+
+// Merkle multiproofs open a set of leaves of a tree with pow2 leaves
+// (nodes numbered in bfs order, root is 1, leaf i is pow2+i) at once:
+// every sibling needed to recompute the root is sent exactly once, unless
+// it can be computed from the opened leaves themselves.
+// Siblings are ordered level by level from the leaves up, and by position
+// within a level.
+
+// return: the sorted, distinct bfs positions of leaves
+func leafPositions(pow2 int64, leaves []int64) []int64 {
+	seen := make(map[int64]bool)
+	var level []int64
+	for _, leaf := range leaves {
+		if !seen[leaf+pow2] {
+			seen[leaf+pow2] = true
+			level = append(level, leaf+pow2)
+		}
+	}
+	sort.Slice(level, func(i, j int) bool { return level[i] < level[j] })
+	return level
+}
+
+// return: bfs positions of the hashes in the multiproof for leaves,
+//         in the order they are sent
+func MultiNodes(pow2 int64, leaves []int64) []int64 {
+	var nodes []int64
+	level := leafPositions(pow2, leaves)
+	for len(level) > 0 && level[0] > 1 {
+		var next []int64
+		for i := 0; i < len(level); i++ {
+			pos := level[i]
+			if pos%2 == 0 && i+1 < len(level) && level[i+1] == pos+1 {
+				i++ // sibling is known as well
+			} else {
+				nodes = append(nodes, pos^1)
+			}
+			next = append(next, pos/2)
+		}
+		level = next
+	}
+	return nodes
+}
+
+// Recompute the merkle root from the labels of the opened leaves and the
+// multiproof; a leaf may be opened more than once, but always with the
+// same label
+// return: the root hash
+func MultiRoot(pow2 int64, leaves []int64, labels [][]byte, multi [][]byte, hash func([]byte) []byte) ([]byte, error) {
+	if len(leaves) != len(labels) {
+		return nil, ErrMultiproof
+	}
+	hashes := make(map[int64][]byte)
+	for i, leaf := range leaves {
+		if leaf < 0 || leaf >= pow2 {
+			return nil, ErrMultiproof
+		}
+		if prev, ok := hashes[leaf+pow2]; ok && !bytes.Equal(prev, labels[i]) {
+			return nil, ErrMultiproof
+		}
+		hashes[leaf+pow2] = labels[i]
+	}
+
+	count := 0
+	level := leafPositions(pow2, leaves)
+	for len(level) > 0 && level[0] > 1 {
+		var next []int64
+		for i := 0; i < len(level); i++ {
+			pos := level[i]
+			var left, right []byte
+			if pos%2 == 0 && i+1 < len(level) && level[i+1] == pos+1 {
+				left, right = hashes[pos], hashes[pos+1]
+				i++
+			} else {
+				if count >= len(multi) {
+					return nil, ErrMultiproof
+				}
+				if pos%2 == 0 {
+					left, right = hashes[pos], multi[count]
+				} else {
+					left, right = multi[count], hashes[pos]
+				}
+				count++
+			}
+			val := append(append([]byte{}, left...), right...)
+			hashes[pos/2] = hash(val)
+			next = append(next, pos/2)
+		}
+		level = next
+	}
+	if count != len(multi) || len(level) == 0 {
+		return nil, ErrMultiproof
+	}
+	return hashes[1], nil
+}
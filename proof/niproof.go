@@ -0,0 +1,75 @@
+package proof
+
+import (
+	"github.com/kwonalbert/pospace/util"
+)
+
+// Encoding (same conventions as Proof):
+//   version          uint16
+//   pk, commit       bytes
+//   hash             uint16
+//   epoch            bytes
+//   proof            bytes (the Proof's own encoding)
+const NIVersion = 1
+
+// Standalone, non-interactive proof of space: the challenges are derived
+// from FiatShamirSeed(Pk, Commit, Epoch), so anyone holding the
+// commitment can check it without talking to the prover
+type NIProof struct {
+	Pk     []byte
+	Commit []byte // merkle root of the prover's commitment
+	Hash   int    // hash function of the commitment
+	Epoch  []byte
+	Proof  *Proof
+}
+
+func (np *NIProof) MarshalBinary() ([]byte, error) {
+	if np.Proof == nil {
+		return nil, ErrMalformed
+	}
+	data, err := np.Proof.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &util.Encoder{}
+	e.Uint16(NIVersion)
+	e.Bytes(np.Pk)
+	e.Bytes(np.Commit)
+	e.Uint16(uint16(np.Hash))
+	e.Bytes(np.Epoch)
+	e.Bytes(data)
+	return e.Data(), nil
+}
+
+func (np *NIProof) UnmarshalBinary(data []byte) error {
+	d := util.NewDecoder(data, ErrMalformed)
+	version := d.Uint16()
+	if d.Err() != nil {
+		return d.Err()
+	}
+	if version != NIVersion {
+		return ErrVersion
+	}
+
+	pk := d.Bytes()
+	commit := d.Bytes()
+	hash := int(d.Uint16())
+	epoch := d.Bytes()
+	data = d.Bytes()
+	if err := d.Finish(); err != nil {
+		return err
+	}
+
+	pf := new(Proof)
+	if err := pf.UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	np.Pk = pk
+	np.Commit = commit
+	np.Hash = hash
+	np.Epoch = epoch
+	np.Proof = pf
+	return nil
+}
@@ -0,0 +1,173 @@
+package proof
+
+import (
+	"errors"
+	"github.com/kwonalbert/pospace/util"
+)
+
+// Versions of the binary encoding
+//
+// Encoding (all integers big endian, bytes are length prefixed by uint32):
+//   version          uint16
+//   #challenges      uint32
+//   per challenge:
+//     challenge      int64
+//     label          bytes
+//     merkle path    uint32 #hashes, then the hashes as bytes (v1 only)
+//     #parents       uint32
+//     per parent:    label bytes, then merkle path (v1 only)
+//   multiproof       uint32 #hashes, then the hashes as bytes (v2 only)
+const (
+	VersionPaths = 1 // a merkle path for every opened label
+	VersionMulti = 2 // one multiproof for all opened labels
+)
+
+var (
+	ErrVersion   = errors.New("proof: unsupported version")
+	ErrMalformed = errors.New("proof: malformed encoding")
+)
+
+// Response of the prover to a set of challenges
+// Either Proofs and PProofs, or Multi proves the labels are in the tree
+type Proof struct {
+	Challenges []int64
+	Hashes     [][]byte     // label of each challenge
+	Parents    [][][]byte   // labels of the parents of each challenge
+	Proofs     [][][]byte   // merkle path of each challenge
+	PProofs    [][][][]byte // merkle paths of the parents of each challenge
+
+	// Multiproof (see MultiNodes) for all challenges and their parents,
+	// opened in the order challenge, its parents, next challenge, ...
+	Multi [][]byte
+}
+
+// return: the nodes opened by the multiproof for challenges, in order
+//         challenge, its parents, next challenge, ...
+func Nodes(challenges []int64, parents [][]int64) []int64 {
+	var nodes []int64
+	for i := range challenges {
+		nodes = append(nodes, challenges[i])
+		nodes = append(nodes, parents[i]...)
+	}
+	return nodes
+}
+
+// return: the labels of the opened nodes, in the same order as Nodes
+func (p *Proof) Labels() [][]byte {
+	var labels [][]byte
+	for i := range p.Hashes {
+		labels = append(labels, p.Hashes[i])
+		if i < len(p.Parents) {
+			labels = append(labels, p.Parents[i]...)
+		}
+	}
+	return labels
+}
+
+func encodePath(e *util.Encoder, path [][]byte) {
+	e.Uint32(len(path))
+	for _, hash := range path {
+		e.Bytes(hash)
+	}
+}
+
+func decodePath(d *util.Decoder) [][]byte {
+	path := make([][]byte, d.Count(4))
+	for i := range path {
+		path[i] = d.Bytes()
+	}
+	return path
+}
+
+// Proofs with a multiproof are encoded as VersionMulti, and as
+// VersionPaths otherwise
+func (p *Proof) MarshalBinary() ([]byte, error) {
+	n := len(p.Challenges)
+	multi := p.Multi != nil
+	if len(p.Hashes) != n || len(p.Parents) != n ||
+		(!multi && (len(p.Proofs) != n || len(p.PProofs) != n)) {
+		return nil, ErrMalformed
+	}
+
+	e := &util.Encoder{}
+	if multi {
+		e.Uint16(VersionMulti)
+	} else {
+		e.Uint16(VersionPaths)
+	}
+	e.Uint32(n)
+	for i := range p.Challenges {
+		if !multi && len(p.Parents[i]) != len(p.PProofs[i]) {
+			return nil, ErrMalformed
+		}
+		e.Int64(p.Challenges[i])
+		e.Bytes(p.Hashes[i])
+		if !multi {
+			encodePath(e, p.Proofs[i])
+		}
+		e.Uint32(len(p.Parents[i]))
+		for j := range p.Parents[i] {
+			e.Bytes(p.Parents[i][j])
+			if !multi {
+				encodePath(e, p.PProofs[i][j])
+			}
+		}
+	}
+	if multi {
+		encodePath(e, p.Multi)
+	}
+	return e.Data(), nil
+}
+
+func (p *Proof) UnmarshalBinary(data []byte) error {
+	d := util.NewDecoder(data, ErrMalformed)
+	version := d.Uint16()
+	if d.Err() != nil {
+		return d.Err()
+	}
+	if version != VersionPaths && version != VersionMulti {
+		return ErrVersion
+	}
+	multi := version == VersionMulti
+
+	// a challenge takes at least 8 + 2*4 bytes
+	n := d.Count(16)
+	challenges := make([]int64, n)
+	hashes := make([][]byte, n)
+	parents := make([][][]byte, n)
+	var proofs [][][]byte
+	var pProofs [][][][]byte
+	var multiproof [][]byte
+	if !multi {
+		proofs = make([][][]byte, n)
+		pProofs = make([][][][]byte, n)
+	}
+	for i := 0; i < n && d.Err() == nil; i++ {
+		challenges[i] = d.Int64()
+		hashes[i] = d.Bytes()
+		if !multi {
+			proofs[i] = decodePath(d)
+		}
+		np := d.Count(4)
+		for j := 0; j < np && d.Err() == nil; j++ {
+			parents[i] = append(parents[i], d.Bytes())
+			if !multi {
+				pProofs[i] = append(pProofs[i], decodePath(d))
+			}
+		}
+	}
+	if multi {
+		multiproof = decodePath(d)
+	}
+	if err := d.Finish(); err != nil {
+		return err
+	}
+
+	p.Challenges = challenges
+	p.Hashes = hashes
+	p.Parents = parents
+	p.Proofs = proofs
+	p.PProofs = pProofs
+	p.Multi = multiproof
+	return nil
+}
@@ -0,0 +1,119 @@
+package proof
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"log"
+	"testing"
+)
+
+func testProof() *Proof {
+	return &Proof{
+		Challenges: []int64{3, 0},
+		Hashes:     [][]byte{{1, 2}, {3}},
+		Parents:    [][][]byte{{{4}, {5, 6}}, nil},
+		Proofs:     [][][]byte{{{7}, {8}}, {{9}}},
+		PProofs:    [][][][]byte{{{{10}}, {{11}, {12}}}, nil},
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	exp := testProof()
+	data, err := exp.MarshalBinary()
+	if err != nil {
+		log.Fatal("Marshal failed:", err)
+	}
+
+	res := new(Proof)
+	if err = res.UnmarshalBinary(data); err != nil {
+		log.Fatal("Unmarshal failed:", err)
+	}
+	again, _ := res.MarshalBinary()
+	if !bytes.Equal(data, again) {
+		log.Fatal("Round trip changed the proof:", data, again)
+	}
+	if res.Challenges[0] != 3 || len(res.Parents[0]) != 2 ||
+		!bytes.Equal(res.PProofs[0][1][1], []byte{12}) {
+		log.Fatal("Wrong proof after round trip:", res)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	data, _ := testProof().MarshalBinary()
+	for i := 0; i < len(data); i++ {
+		if new(Proof).UnmarshalBinary(data[:i]) == nil {
+			log.Fatal("Accepted truncated proof of length ", i)
+		}
+	}
+	if new(Proof).UnmarshalBinary(append(data, 0)) != ErrMalformed {
+		log.Fatal("Accepted trailing data")
+	}
+	data[1] = 9
+	if new(Proof).UnmarshalBinary(data) != ErrVersion {
+		log.Fatal("Accepted unknown version")
+	}
+}
+
+func sha(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// return: full bfs merkle tree over pow2 leaves
+func testTree(pow2 int64) [][]byte {
+	tree := make([][]byte, 2*pow2)
+	for i := pow2; i < 2*pow2; i++ {
+		tree[i] = sha([]byte{byte(i)})
+	}
+	for i := pow2 - 1; i > 0; i-- {
+		tree[i] = sha(append(append([]byte{}, tree[2*i]...), tree[2*i+1]...))
+	}
+	return tree
+}
+
+func TestMultiproof(t *testing.T) {
+	pow2 := int64(64)
+	tree := testTree(pow2)
+	leaves := []int64{5, 4, 17, 5, 40, 63, 41}
+
+	labels := make([][]byte, len(leaves))
+	paths := 0
+	for i, leaf := range leaves {
+		labels[i] = tree[leaf+pow2]
+		paths += 6
+	}
+	nodes := MultiNodes(pow2, leaves)
+	multi := make([][]byte, len(nodes))
+	for i, node := range nodes {
+		multi[i] = tree[node]
+	}
+	if len(multi) >= paths/2 {
+		log.Fatal("Multiproof is not compact:", len(multi), paths)
+	}
+
+	root, err := MultiRoot(pow2, leaves, labels, multi, sha)
+	if err != nil || !bytes.Equal(root, tree[1]) {
+		log.Fatal("Multiproof root mismatch:", err)
+	}
+
+	labels[3] = labels[0][:4] // same leaf opened with another label
+	if _, err = MultiRoot(pow2, leaves, labels, multi, sha); err != ErrMultiproof {
+		log.Fatal("Accepted inconsistent labels")
+	}
+	labels[3] = labels[0]
+	if _, err = MultiRoot(pow2, leaves, labels, multi[1:], sha); err != ErrMultiproof {
+		log.Fatal("Accepted short multiproof")
+	}
+
+	pf := &Proof{
+		Challenges: []int64{1},
+		Hashes:     [][]byte{{1}},
+		Parents:    [][][]byte{{{2}}},
+		Multi:      multi,
+	}
+	data, _ := pf.MarshalBinary()
+	res := new(Proof)
+	if err = res.UnmarshalBinary(data); err != nil || len(res.Multi) != len(multi) {
+		log.Fatal("Multiproof round trip failed:", err)
+	}
+}
@@ -0,0 +1,165 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/kwonalbert/pospace/proof"
+	"github.com/kwonalbert/pospace/prover"
+	"github.com/kwonalbert/pospace/verifier"
+	"net"
+	"time"
+)
+
+// Verifier side of a session with a Server
+type Client struct {
+	cn      *conn
+	version uint16 // negotiated protocol version
+}
+
+// Connect to the server at addr (over TCP), see NewClient
+func Dial(addr string, timeout time.Duration) (*Client, error) {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(c, timeout, DefaultMaxMessage)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Start a session on c, and agree on a protocol version with the server
+// timeout limits the time to send or receive one message (0 for no
+// limit), and maxMessage the payload of the messages the client accepts
+func NewClient(c net.Conn, timeout time.Duration, maxMessage int) (*Client, error) {
+	cn := &conn{c: c, timeout: timeout, maxMessage: maxMessage}
+	err := cn.write(MsgHello, hello(MinVersion, MaxVersion))
+	if err != nil {
+		return nil, err
+	}
+	payload, err := cn.expect(MsgHello)
+	if remote, ok := err.(*RemoteError); ok && remote.Msg == ErrVersion.Error() {
+		return nil, ErrVersion
+	} else if err != nil {
+		return nil, err
+	}
+	if len(payload) != 2 {
+		return nil, ErrMalformed
+	}
+	version := binary.BigEndian.Uint16(payload)
+	if version < MinVersion || version > MaxVersion {
+		return nil, ErrVersion
+	}
+	return &Client{cn: cn, version: version}, nil
+}
+
+func (c *Client) Version() int {
+	return int(c.version)
+}
+
+// Direct the following requests to the server's space for pk, index and
+// plot (needs version 2, and version 3 for plots other than 0)
+func (c *Client) Select(pk []byte, index, plot int64) error {
+	if c.version < 2 || (c.version < 3 && plot != 0) {
+		return ErrVersion
+	}
+	key := spaceKey(pk, index, plot)
+	if c.version < 3 {
+		key = key[:len(key)-8]
+	}
+	if err := c.cn.write(MsgSelect, key); err != nil {
+		return err
+	}
+	_, err := c.cn.expect(MsgSelect)
+	return err
+}
+
+// Ask the server for the prover's commitment
+func (c *Client) Commitment() (*prover.Commitment, error) {
+	if err := c.cn.write(MsgCommit, nil); err != nil {
+		return nil, err
+	}
+	payload, err := c.cn.expect(MsgCommit)
+	if err != nil {
+		return nil, err
+	}
+	commit := new(prover.Commitment)
+	if err := commit.UnmarshalBinary(payload); err != nil {
+		return nil, err
+	}
+	return commit, nil
+}
+
+// Send challenges to the server
+// return: the prover's (unverified) proof for them
+func (c *Client) Prove(challenges []int64) (*proof.Proof, error) {
+	if err := c.cn.write(MsgChallenge, proof.MarshalChallenges(challenges)); err != nil {
+		return nil, err
+	}
+	payload, err := c.cn.expect(MsgProof)
+	if err != nil {
+		return nil, err
+	}
+	pf := new(proof.Proof)
+	if err := pf.UnmarshalBinary(payload); err != nil {
+		return nil, err
+	}
+	return pf, nil
+}
+
+// Tell the server whether its proof was accepted
+func (c *Client) Result(ok bool) error {
+	payload := []byte{0}
+	if ok {
+		payload[0] = 1
+	}
+	if err := c.cn.write(MsgResult, payload); err != nil {
+		return err
+	}
+	_, err := c.cn.expect(MsgResult)
+	return err
+}
+
+// Run one round of the protocol: select the space of pk, index and plot
+// (on servers that speak version 2), fetch its commitment and check it is
+// for the right graph and plot, challenge it with a fresh random seed,
+// verify the proof and report the result to the server
+// return: whether the proof was accepted
+func (c *Client) Verify(pk []byte, index, plot int64, beta int, graphDir string) (bool, error) {
+	if c.version >= 2 || plot != 0 {
+		if err := c.Select(pk, index, plot); err != nil {
+			return false, err
+		}
+	}
+	commit, err := c.Commitment()
+	if err != nil {
+		return false, err
+	}
+	v, err := verifier.NewVerifier(pk, index, commit.Hash, beta, commit.Commit, graphDir)
+	if err != nil {
+		return false, err
+	}
+	v.SetPlot(plot)
+	if !bytes.Equal(commit.Pk, pk) || commit.Plot != plot || !v.VerifyGraph(commit.Graph) {
+		return false, c.Result(false)
+	}
+
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return false, err
+	}
+	challenges := v.SelectChallenges(seed)
+	pf, err := c.Prove(challenges)
+	if err != nil {
+		return false, err
+	}
+	ok := v.VerifySpace(challenges, pf)
+	return ok, c.Result(ok)
+}
+
+func (c *Client) Close() error {
+	return c.cn.c.Close()
+}
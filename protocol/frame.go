@@ -0,0 +1,172 @@
+// Package protocol runs the proof of space protocol between a prover
+// (Server) and a verifier (Client) over a net.Conn
+//
+// Every message is a frame (big endian):
+//   type             uint8, one of the Msg constants
+//   length           uint32, at most the receiver's message size limit
+//   payload          length bytes
+//
+// A session starts with the client sending MsgHello with the range of
+// versions it speaks, which the server answers with MsgHello and the
+// version it picked (or MsgError). After that, each client request gets
+// exactly one response:
+//   MsgCommit (empty)               -> MsgCommit (prover.Commitment)
+//   MsgChallenge (challenges)       -> MsgProof (proof.Proof)
+//   MsgResult (uint8, 1 if valid)   -> MsgResult (empty)
+//   MsgSelect (space, version 2)    -> MsgSelect (empty)
+// where challenges are encoded with proof.MarshalChallenges. Any request
+// can instead be answered by MsgError (a message string).
+//
+// A server can hold several spaces; MsgCommit and MsgChallenge go to the
+// selected one, which is the server's first space until MsgSelect picks
+// another by its pk (uint16 length, then the bytes), index (int64) and,
+// from version 3, plot id (int64); before that it picks plot 0.
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Message types
+const (
+	MsgHello     = iota + 1
+	MsgCommit    = iota + 1
+	MsgChallenge = iota + 1
+	MsgProof     = iota + 1
+	MsgResult    = iota + 1
+	MsgError     = iota + 1
+	MsgSelect    = iota + 1
+)
+
+// Protocol versions this package speaks
+// Version 2 added MsgSelect, and version 3 the plot id in it
+const (
+	MinVersion = 1
+	MaxVersion = 3
+)
+
+const (
+	// Default limit on the payload of a received message
+	DefaultMaxMessage = 64 << 20
+	// Default limit on the time to send or receive one message
+	DefaultTimeout = 30 * time.Second
+	// Default limit on the challenges of one MsgChallenge
+	DefaultMaxChallenges = 1 << 14
+)
+
+const frameHeaderSize = 5
+
+var helloMagic = []byte("pospace")
+
+var (
+	ErrVersion    = errors.New("protocol: no common protocol version")
+	ErrTooLarge   = errors.New("protocol: message exceeds the size limit")
+	ErrMalformed  = errors.New("protocol: malformed message")
+	ErrUnexpected = errors.New("protocol: unexpected message type")
+	ErrNoSpace    = errors.New("protocol: no such space")
+	ErrDupSpace   = errors.New("protocol: space is already served")
+	ErrBusy       = errors.New("protocol: server is at its connection limit")
+	ErrRateLimit  = errors.New("protocol: rate limit exceeded")
+	ErrTooMany    = errors.New("protocol: too many challenges in one request")
+)
+
+// Error reported by the other side with MsgError
+type RemoteError struct {
+	Msg string
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("protocol: remote error: %s", e.Msg)
+}
+
+// One side of a connection; every read and write has its own deadline
+type conn struct {
+	c          net.Conn
+	timeout    time.Duration
+	maxMessage int
+}
+
+func (c *conn) deadline() time.Time {
+	if c.timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.timeout)
+}
+
+func (c *conn) write(typ byte, payload []byte) error {
+	if len(payload) > c.maxMessage {
+		return ErrTooLarge
+	}
+	buf := make([]byte, frameHeaderSize, frameHeaderSize+len(payload))
+	buf[0] = typ
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(payload)))
+	buf = append(buf, payload...)
+
+	if err := c.c.SetWriteDeadline(c.deadline()); err != nil {
+		return err
+	}
+	_, err := c.c.Write(buf)
+	return err
+}
+
+// The size limit is checked before the payload is read (or allocated)
+func (c *conn) read() (byte, []byte, error) {
+	if err := c.c.SetReadDeadline(c.deadline()); err != nil {
+		return 0, nil, err
+	}
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(c.c, header); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if int64(n) > int64(c.maxMessage) {
+		return 0, nil, ErrTooLarge
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.c, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// Read a message of type typ; MsgError is returned as a *RemoteError
+func (c *conn) expect(typ byte) ([]byte, error) {
+	t, payload, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if t == MsgError {
+		return nil, &RemoteError{string(payload)}
+	}
+	if t != typ {
+		return nil, ErrUnexpected
+	}
+	return payload, nil
+}
+
+func hello(min, max uint16) []byte {
+	buf := append([]byte{}, helloMagic...)
+	buf = binary.BigEndian.AppendUint16(buf, min)
+	return binary.BigEndian.AppendUint16(buf, max)
+}
+
+func spaceKey(pk []byte, index, plot int64) []byte {
+	buf := binary.BigEndian.AppendUint16(nil, uint16(len(pk)))
+	buf = append(buf, pk...)
+	buf = binary.BigEndian.AppendUint64(buf, uint64(index))
+	return binary.BigEndian.AppendUint64(buf, uint64(plot))
+}
+
+func parseHello(payload []byte) (uint16, uint16, error) {
+	if len(payload) != len(helloMagic)+4 || !bytes.HasPrefix(payload, helloMagic) {
+		return 0, 0, ErrMalformed
+	}
+	payload = payload[len(helloMagic):]
+	return binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:]), nil
+}
@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"sync"
+	"time"
+)
+
+// Buckets beyond this many are dropped once they are full again
+const maxBuckets = 1 << 12
+
+// Token bucket rate limit per client: rate requests per second on
+// average, in bursts of up to burst requests
+// A nil *limiter allows everything
+type limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// refill b up to now
+func (l *limiter) refill(b *bucket, now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+}
+
+// return: whether client may make another request now (taking a token)
+func (l *limiter) allow(client string) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			for c, b := range l.buckets {
+				if l.refill(b, now); b.tokens >= l.burst {
+					delete(l.buckets, c)
+				}
+			}
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	l.refill(b, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
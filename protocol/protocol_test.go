@@ -0,0 +1,310 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kwonalbert/pospace/prover"
+	"github.com/kwonalbert/pospace/util"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+var pk = []byte{1}
+
+// Start a server for an index 4 prover on a loopback port
+func startServer(dir string) (*Server, string) {
+	p, err := prover.NewProver(pk, 4, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("New prover failed:", err)
+	}
+	_, err = p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+	s, err := NewServer(p)
+	if err != nil {
+		log.Fatal("New server failed:", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal("Listen failed:", err)
+	}
+	go s.Serve(l)
+	return s, l.Addr().String()
+}
+
+func TestLoopback(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	s, addr := startServer(dir)
+	defer s.Close()
+
+	c, err := Dial(addr, time.Second)
+	if err != nil {
+		log.Fatal("Dial failed:", err)
+	}
+	defer c.Close()
+	if c.Version() != MaxVersion {
+		log.Fatal("Negotiated version ", c.Version())
+	}
+	for i := 0; i < 3; i++ {
+		ok, err := c.Verify(pk, 4, 0, 1, dir)
+		if err != nil || !ok {
+			log.Fatal("Verify failed: ", ok, err)
+		}
+	}
+
+	// the server has no space for another pk
+	ok, err := c.Verify([]byte{2}, 4, 0, 1, dir)
+	var remote *RemoteError
+	if ok || !errors.As(err, &remote) || remote.Msg != ErrNoSpace.Error() {
+		log.Fatal("Accepted a commitment from the wrong pk: ", ok, err)
+	}
+
+	// bad challenges are reported, and the session goes on
+	_, err = c.Prove([]int64{-1})
+	if !errors.As(err, &remote) {
+		log.Fatal("Expected a remote error:", err)
+	}
+	if _, err = c.Commitment(); err != nil {
+		log.Fatal("Session ended after a remote error:", err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	s, addr := startServer(dir)
+	defer s.Close()
+
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		log.Fatal("Dial failed:", err)
+	}
+	defer nc.Close()
+	cn := &conn{c: nc, timeout: time.Second, maxMessage: DefaultMaxMessage}
+	cn.write(MsgHello, hello(MaxVersion+1, MaxVersion+2))
+	_, err = cn.expect(MsgHello)
+	var remote *RemoteError
+	if !errors.As(err, &remote) {
+		log.Fatal("Server accepted an unknown version:", err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	s, addr := startServer(dir)
+	defer s.Close()
+	s.SetMaxMessage(64)
+	s.SetTimeout(100 * time.Millisecond)
+
+	c, err := Dial(addr, time.Second)
+	if err != nil {
+		log.Fatal("Dial failed:", err)
+	}
+	_, err = c.Prove(make([]int64, 100))
+	if err == nil {
+		log.Fatal("Server accepted an oversized message")
+	}
+	c.Close()
+
+	// the server hangs up on idle clients
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		log.Fatal("Dial failed:", err)
+	}
+	defer nc.Close()
+	nc.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = nc.Read(make([]byte, 1))
+	if err != io.EOF {
+		log.Fatal("Idle client was not dropped:", err)
+	}
+
+	// and the client gives up on a server that does not answer
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			defer c.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+	start := time.Now()
+	_, err = Dial(l.Addr().String(), 100*time.Millisecond)
+	if err == nil || time.Since(start) > 500*time.Millisecond {
+		log.Fatal("Client did not time out:", err)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	s, addr := startServer(dir)
+	defer s.Close()
+	s.SetWorkers(2)
+
+	// a second space, for another pk and index
+	pk2 := []byte{2}
+	p, err := prover.NewProver(pk2, 5, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("New prover failed:", err)
+	}
+	_, err = p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+	if err := s.AddSpace(p); err != nil {
+		log.Fatal("Add space failed:", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := Dial(addr, time.Second)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer c.Close()
+			for j := 0; j < 3; j++ {
+				var ok bool
+				if i%2 == 0 {
+					ok, err = c.Verify(pk, 4, 0, 1, dir)
+				} else {
+					ok, err = c.Verify(pk2, 5, 0, 1, dir)
+				}
+				if err != nil || !ok {
+					errs <- fmt.Errorf("client %d: %v %v", i, ok, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		log.Fatal("Verify failed: ", err)
+	}
+}
+
+func TestPlots(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	s, addr := startServer(dir)
+	defer s.Close()
+
+	// a second plot of the same pk and index
+	p, err := prover.NewPlot(pk, 4, 1, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("New plot failed:", err)
+	}
+	_, err = p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+	if err := s.AddSpace(p); err != nil {
+		log.Fatal("Add space failed:", err)
+	}
+	if err := s.AddSpace(p); err != ErrDupSpace {
+		log.Fatal("Served a plot twice:", err)
+	}
+
+	c, err := Dial(addr, time.Second)
+	if err != nil {
+		log.Fatal("Dial failed:", err)
+	}
+	defer c.Close()
+	for _, plot := range []int64{1, 0, 1} {
+		ok, err := c.Verify(pk, 4, plot, 1, dir)
+		if err != nil || !ok {
+			log.Fatal("Verify of plot ", plot, " failed: ", ok, err)
+		}
+	}
+	ok, err := c.Verify(pk, 4, 2, 1, dir)
+	var remote *RemoteError
+	if ok || !errors.As(err, &remote) || remote.Msg != ErrNoSpace.Error() {
+		log.Fatal("Selected a missing plot: ", ok, err)
+	}
+
+	// version 2 clients can only select plot 0
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		log.Fatal("Dial failed:", err)
+	}
+	cn := &conn{c: nc, timeout: time.Second, maxMessage: DefaultMaxMessage}
+	cn.write(MsgHello, hello(1, 2))
+	if _, err := cn.expect(MsgHello); err != nil {
+		log.Fatal("Hello failed:", err)
+	}
+	c2 := &Client{cn: cn, version: 2}
+	defer c2.Close()
+	if ok, err := c2.Verify(pk, 4, 0, 1, dir); err != nil || !ok {
+		log.Fatal("Version 2 verify failed: ", ok, err)
+	}
+	if _, err := c2.Verify(pk, 4, 1, 1, dir); err != ErrVersion {
+		log.Fatal("Version 2 selected plot 1:", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	s, addr := startServer(dir)
+	defer s.Close()
+	s.SetRateLimit(0.01, 2)
+	s.SetMaxConns(1)
+
+	c, err := Dial(addr, time.Second)
+	if err != nil {
+		log.Fatal("Dial failed:", err)
+	}
+	defer c.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := c.Prove([]int64{0}); err != nil {
+			log.Fatal("Prove failed:", err)
+		}
+	}
+	_, err = c.Prove([]int64{0})
+	var remote *RemoteError
+	if !errors.As(err, &remote) || remote.Msg != ErrRateLimit.Error() {
+		log.Fatal("Challenges were not rate limited:", err)
+	}
+
+	// only one connection at a time
+	_, err = Dial(addr, time.Second)
+	if !errors.As(err, &remote) || remote.Msg != ErrBusy.Error() {
+		log.Fatal("Connection limit was not enforced:", err)
+	}
+}
+
+func TestMaxChallenges(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	s, addr := startServer(dir)
+	defer s.Close()
+	s.SetMaxChallenges(2)
+
+	c, err := Dial(addr, time.Second)
+	if err != nil {
+		log.Fatal("Dial failed:", err)
+	}
+	defer c.Close()
+	if _, err := c.Prove([]int64{0, 1}); err != nil {
+		log.Fatal("Prove failed:", err)
+	}
+	_, err = c.Prove([]int64{0, 1, 2})
+	var remote *RemoteError
+	if !errors.As(err, &remote) || remote.Msg != ErrTooMany.Error() {
+		log.Fatal("Oversized request was not refused:", err)
+	}
+}
@@ -0,0 +1,330 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/kwonalbert/pospace/proof"
+	"github.com/kwonalbert/pospace/prover"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Serves the commitments of initialized provers, and their proofs, to any
+// number of concurrent clients
+type Server struct {
+	mu     sync.Mutex
+	spaces map[string]*space // by spaceKey
+	first  *space            // selected at the start of every session
+
+	timeout    time.Duration
+	maxMessage int
+	maxConns   int           // 0 for no limit
+	maxChalls  int           // per request, 0 for no limit
+	workers    chan struct{} // one token per proof being computed
+	limiter    *limiter      // of challenge requests per client host
+
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+type space struct {
+	p      *prover.Prover
+	commit []byte // encoded commitment of p
+}
+
+// State of one client's session
+type session struct {
+	cn        *conn
+	version   uint16
+	space     *space
+	client    string // host of the client, for the rate limit
+	workers   chan struct{}
+	limiter   *limiter
+	maxChalls int
+}
+
+var ErrServerClosed = errors.New("protocol: server closed")
+
+// p must be initialized (Init or OpenProver); see AddSpace for more
+// By default proofs are computed by one worker per CPU, requests are
+// limited to DefaultMaxChallenges challenges, and clients are not rate
+// limited
+func NewServer(p *prover.Prover) (*Server, error) {
+	s := &Server{
+		spaces:     make(map[string]*space),
+		timeout:    DefaultTimeout,
+		maxMessage: DefaultMaxMessage,
+		maxChalls:  DefaultMaxChallenges,
+		workers:    make(chan struct{}, runtime.NumCPU()),
+		listeners:  make(map[net.Listener]bool),
+		conns:      make(map[net.Conn]bool),
+	}
+	if err := s.AddSpace(p); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Serve another initialized prover; clients pick it with Client.Select
+// Each pk, index and plot can only be served once (ErrDupSpace)
+func (s *Server) AddSpace(p *prover.Prover) error {
+	commit, err := p.PreInit()
+	if err != nil {
+		return err
+	}
+	data, err := commit.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	sp := &space{p: p, commit: data}
+	key := string(spaceKey(commit.Pk, p.Index(), p.Plot()))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.spaces[key] != nil {
+		return ErrDupSpace
+	}
+	s.spaces[key] = sp
+	if s.first == nil {
+		s.first = sp
+	}
+	return nil
+}
+
+// Limit the time to send or receive one message, which is also how long
+// an idle client is kept (0 for no limit); applies to new connections
+func (s *Server) SetTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	s.timeout = timeout
+	s.mu.Unlock()
+}
+
+// Limit the payload of the messages the server accepts; applies to new
+// connections
+func (s *Server) SetMaxMessage(n int) {
+	s.mu.Lock()
+	s.maxMessage = n
+	s.mu.Unlock()
+}
+
+// Turn away clients beyond n concurrent connections (0 for no limit)
+func (s *Server) SetMaxConns(n int) {
+	s.mu.Lock()
+	s.maxConns = n
+	s.mu.Unlock()
+}
+
+// Compute at most workers proofs at a time, across all clients; applies
+// to new connections
+func (s *Server) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	s.mu.Lock()
+	s.workers = make(chan struct{}, workers)
+	s.mu.Unlock()
+}
+
+// Allow each client host rate challenge requests per second on average,
+// in bursts of up to burst; more are answered with ErrRateLimit (rate 0
+// for no limit). The work of a request is bounded by SetMaxChallenges.
+// Applies to new connections
+func (s *Server) SetRateLimit(rate float64, burst int) {
+	s.mu.Lock()
+	if rate <= 0 {
+		s.limiter = nil
+	} else {
+		s.limiter = newLimiter(rate, burst)
+	}
+	s.mu.Unlock()
+}
+
+// Answer requests of more than n challenges with ErrTooMany (0 for no
+// limit); applies to new connections
+func (s *Server) SetMaxChallenges(n int) {
+	s.mu.Lock()
+	s.maxChalls = n
+	s.mu.Unlock()
+}
+
+// Accept connections on l and serve each of them in its own goroutine,
+// until Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.ServeConn(c)
+		}()
+	}
+}
+
+// Serve one client until it hangs up, errs or times out; closes c
+func (s *Server) ServeConn(c net.Conn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		c.Close()
+		return ErrServerClosed
+	}
+	ss := &session{
+		cn:        &conn{c: c, timeout: s.timeout, maxMessage: s.maxMessage},
+		space:     s.first,
+		client:    host(c.RemoteAddr()),
+		workers:   s.workers,
+		limiter:   s.limiter,
+		maxChalls: s.maxChalls,
+	}
+	busy := s.maxConns > 0 && len(s.conns) >= s.maxConns
+	if !busy {
+		s.conns[c] = true
+	}
+	s.mu.Unlock()
+	if busy {
+		ss.cn.write(MsgError, []byte(ErrBusy.Error()))
+		c.Close()
+		return ErrBusy
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	if err := s.handshake(ss); err != nil {
+		return err
+	}
+	for {
+		typ, payload, err := ss.cn.read()
+		if err != nil {
+			return err
+		}
+		err = s.handle(ss, typ, payload)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func host(addr net.Addr) string {
+	h, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return h
+}
+
+func (s *Server) handshake(ss *session) error {
+	payload, err := ss.cn.expect(MsgHello)
+	if err != nil {
+		return err
+	}
+	min, max, err := parseHello(payload)
+	if err != nil {
+		ss.cn.write(MsgError, []byte(err.Error()))
+		return err
+	}
+	if min > MaxVersion || max < MinVersion || min > max {
+		ss.cn.write(MsgError, []byte(ErrVersion.Error()))
+		return ErrVersion
+	}
+	ss.version = MaxVersion
+	if max < ss.version {
+		ss.version = max
+	}
+	return ss.cn.write(MsgHello, binary.BigEndian.AppendUint16(nil, ss.version))
+}
+
+// Answer one request; only errors on the connection end the session
+func (s *Server) handle(ss *session, typ byte, payload []byte) error {
+	switch typ {
+	case MsgCommit:
+		return ss.cn.write(MsgCommit, ss.space.commit)
+	case MsgChallenge:
+		if !ss.limiter.allow(ss.client) {
+			return ss.cn.write(MsgError, []byte(ErrRateLimit.Error()))
+		}
+		challenges, err := proof.UnmarshalChallenges(payload)
+		if err != nil {
+			return ss.cn.write(MsgError, []byte(err.Error()))
+		}
+		if ss.maxChalls > 0 && len(challenges) > ss.maxChalls {
+			return ss.cn.write(MsgError, []byte(ErrTooMany.Error()))
+		}
+		ss.workers <- struct{}{}
+		pf, err := ss.space.p.ProveSpace(challenges)
+		<-ss.workers
+		if err != nil {
+			return ss.cn.write(MsgError, []byte(err.Error()))
+		}
+		data, err := pf.MarshalBinary()
+		if err != nil {
+			return ss.cn.write(MsgError, []byte(err.Error()))
+		}
+		return ss.cn.write(MsgProof, data)
+	case MsgResult:
+		if len(payload) != 1 {
+			return ss.cn.write(MsgError, []byte(ErrMalformed.Error()))
+		}
+		return ss.cn.write(MsgResult, nil)
+	case MsgSelect:
+		if ss.version < 2 {
+			break
+		}
+		key := payload
+		if ss.version < 3 {
+			// no plot id, so plot 0
+			key = binary.BigEndian.AppendUint64(append([]byte{}, payload...), 0)
+		}
+		s.mu.Lock()
+		sp, ok := s.spaces[string(key)]
+		s.mu.Unlock()
+		if !ok {
+			return ss.cn.write(MsgError, []byte(ErrNoSpace.Error()))
+		}
+		ss.space = sp
+		return ss.cn.write(MsgSelect, nil)
+	}
+	ss.cn.write(MsgError, []byte(ErrUnexpected.Error()))
+	return ErrUnexpected
+}
+
+// Stop accepting connections, hang up on all clients, and wait for their
+// goroutines to finish; the provers are left open
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
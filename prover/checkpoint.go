@@ -0,0 +1,145 @@
+package prover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"github.com/kwonalbert/pospace/util"
+	"os"
+)
+
+// Init saves a checkpoint at least every checkpointNodes labels (or merkle
+// hashes), so an interrupted Init picks up from there instead of node 0
+var checkpointNodes = int64(1 << 22)
+
+var errCheckpoint = errors.New("prover: malformed checkpoint")
+
+// Progress of an unfinished Init, kept next to the space file
+//
+// Checkpoint file (big endian):
+//   labeled          int64, nodes [0, labeled) are labeled
+//   merkle           uint8, 1 iff the merkle state follows
+//   cur, count       int64 each, see generateMerkle
+//   stack            uint32 length, then int64 each
+//   hash stack       uint32 length, then hashSize bytes each
+//   checksum         sha256 of all the preceding bytes
+type checkpoint struct {
+	labeled int64
+	merkle  *merkleState // nil until all nodes are labeled
+}
+
+// Everything generateMerkle needs to continue from the top of its loop
+type merkleState struct {
+	cur       int64
+	count     int64
+	stack     []int64
+	hashStack [][]byte
+}
+
+func (c *checkpoint) marshal() []byte {
+	e := &util.Encoder{}
+	e.Int64(c.labeled)
+	if c.merkle == nil {
+		e.Uint8(0)
+	} else {
+		m := c.merkle
+		e.Uint8(1)
+		e.Int64(m.cur)
+		e.Int64(m.count)
+		e.Uint32(len(m.stack))
+		for _, v := range m.stack {
+			e.Int64(v)
+		}
+		e.Uint32(len(m.hashStack))
+		for _, hash := range m.hashStack {
+			e.Raw(hash)
+		}
+	}
+	sum := sha256.Sum256(e.Data())
+	return append(e.Data(), sum[:]...)
+}
+
+func parseCheckpoint(data []byte) (*checkpoint, error) {
+	if len(data) < sha256.Size {
+		return nil, errCheckpoint
+	}
+	body := data[:len(data)-sha256.Size]
+	sum := sha256.Sum256(body)
+	if !bytes.Equal(sum[:], data[len(body):]) {
+		return nil, errCheckpoint
+	}
+
+	d := util.NewDecoder(body, errCheckpoint)
+	c := &checkpoint{}
+	c.labeled = d.Int64()
+	if d.Uint8() == 1 {
+		m := &merkleState{}
+		m.cur = d.Int64()
+		m.count = d.Int64()
+		m.stack = make([]int64, d.Count(8))
+		for i := range m.stack {
+			m.stack[i] = d.Int64()
+		}
+		m.hashStack = make([][]byte, d.Count(hashSize))
+		for i := range m.hashStack {
+			m.hashStack[i] = append([]byte{}, d.Next(hashSize)...)
+		}
+		c.merkle = m
+	}
+	if err := d.Finish(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Persist c, once everything it covers is on disk
+// The checkpoint is replaced atomically, so a crash at any point leaves
+// either the old or the new one
+func (p *Prover) saveCheckpoint(c *checkpoint) error {
+	err := p.sync()
+	if err != nil {
+		return err
+	}
+
+	tmp := p.ckpt + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(c.marshal())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, p.ckpt)
+}
+
+// return: the checkpoint of an unfinished Init of this space, or nil if
+//         Init has to start over
+func (p *Prover) loadCheckpoint() *checkpoint {
+	data, err := os.ReadFile(p.ckpt)
+	if err != nil {
+		return nil
+	}
+	c, err := parseCheckpoint(data)
+	if err != nil {
+		return nil
+	}
+	// the space must still belong to this prover, have the same layout,
+	// and not be complete
+	h, err := p.readHeader()
+	if err != nil || len(h.root) != 0 || !h.layout.equal(&p.layout) {
+		return nil
+	}
+	if c.labeled < 0 || c.labeled > p.graph.GetSize() ||
+		(c.merkle != nil && c.labeled != p.graph.GetSize()) {
+		return nil
+	}
+	return c
+}
@@ -0,0 +1,50 @@
+package prover
+
+import (
+	"errors"
+	"github.com/kwonalbert/pospace/util"
+)
+
+// Encoding of a Commitment (with util.Encoder: big endian, bytes are
+// length prefixed by uint32):
+//   version          uint16, CommitmentVersion
+//   pk, commit       bytes
+//   hash             uint16
+//   graph            bytes
+//   plot             int64
+const CommitmentVersion = 1
+
+var ErrCommitment = errors.New("prover: malformed commitment")
+
+func (c *Commitment) MarshalBinary() ([]byte, error) {
+	e := &util.Encoder{}
+	e.Uint16(CommitmentVersion)
+	e.Bytes(c.Pk)
+	e.Bytes(c.Commit)
+	e.Uint16(uint16(c.Hash))
+	e.Bytes(c.Graph)
+	e.Int64(c.Plot)
+	return e.Data(), nil
+}
+
+func (c *Commitment) UnmarshalBinary(data []byte) error {
+	d := util.NewDecoder(data, ErrCommitment)
+	if d.Uint16() != CommitmentVersion {
+		return ErrCommitment
+	}
+	pk := d.Bytes()
+	commit := d.Bytes()
+	hash := int(d.Uint16())
+	graph := d.Bytes()
+	plot := d.Int64()
+	if err := d.Finish(); err != nil {
+		return err
+	}
+
+	c.Pk = pk
+	c.Commit = commit
+	c.Hash = hash
+	c.Graph = graph
+	c.Plot = plot
+	return nil
+}
@@ -0,0 +1,136 @@
+package prover
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"github.com/kwonalbert/pospace/util"
+	"io"
+	"os"
+	"time"
+)
+
+// Space files start with a header of headerSize bytes, followed by the
+// hashes of the labels and the merkle tree (post-order, hashSize each),
+// unless the hashes are in shards (see SetShards)
+//
+// Header (with util.Encoder: big endian, bytes are length prefixed by
+// uint32; zero padded):
+//   magic            8 bytes, spaceMagic
+//   format version   uint16, spaceVersion
+//   pk               bytes
+//   graph type       uint16
+//   index            int64
+//   plot id          int64
+//   hash function    uint16
+//   node count       int64, number of nodes in the graph
+//   merkle root      bytes (empty until Init is done)
+//   creation time    int64, unix nanoseconds
+//   shard size       int64, 0 if not sharded
+//   shard dirs       uint32 count, then each as bytes
+//   checksum         sha256 of all the preceding header bytes
+const headerSize = 4096
+
+const spaceVersion = 1
+
+var spaceMagic = []byte("POSPACE\x00")
+
+var (
+	ErrHeader = errors.New("prover: space file header does not match")
+	ErrFormat = errors.New("prover: space file is corrupt or not a space file")
+	ErrPk     = errors.New("prover: public key too long for the space header")
+	ErrLayout = errors.New("prover: shard directories too long for the space header")
+)
+
+type header struct {
+	pk        []byte
+	graphType int
+	index     int64
+	plot      int64
+	hash      int
+	nodes     int64
+	root      []byte
+	created   time.Time
+	layout    layout
+}
+
+func (h *header) marshal() ([]byte, error) {
+	e := &util.Encoder{}
+	e.Raw(spaceMagic)
+	e.Uint16(spaceVersion)
+	e.Bytes(h.pk)
+	e.Uint16(uint16(h.graphType))
+	e.Int64(h.index)
+	e.Int64(h.plot)
+	e.Uint16(uint16(h.hash))
+	e.Int64(h.nodes)
+	e.Bytes(h.root)
+	e.Int64(h.created.UnixNano())
+	if len(e.Data())+8+4+sha256.Size > headerSize {
+		return nil, ErrPk
+	}
+	e.Int64(h.layout.size)
+	e.Uint32(len(h.layout.dirs))
+	for _, dir := range h.layout.dirs {
+		e.Bytes([]byte(dir))
+	}
+	sum := sha256.Sum256(e.Data())
+	e.Raw(sum[:])
+	if len(e.Data()) > headerSize {
+		return nil, ErrLayout
+	}
+	buf := make([]byte, headerSize)
+	copy(buf, e.Data())
+	return buf, nil
+}
+
+func parseHeader(data []byte) (*header, error) {
+	if len(data) != headerSize {
+		return nil, ErrFormat
+	}
+	d := util.NewDecoder(data, ErrFormat)
+	if !bytes.Equal(d.Next(len(spaceMagic)), spaceMagic) || d.Uint16() != spaceVersion {
+		return nil, ErrFormat
+	}
+	h := &header{}
+	h.pk = d.Bytes()
+	h.graphType = int(d.Uint16())
+	h.index = d.Int64()
+	h.plot = d.Int64()
+	h.hash = int(d.Uint16())
+	h.nodes = d.Int64()
+	h.root = d.Bytes()
+	h.created = time.Unix(0, d.Int64())
+	h.layout.size = d.Int64()
+	dirs := d.Count(4)
+	for i := 0; i < dirs; i++ {
+		h.layout.dirs = append(h.layout.dirs, string(d.Bytes()))
+	}
+	if d.Err() != nil {
+		return nil, d.Err()
+	}
+
+	// the rest of the header after the checksum is padding
+	sum := sha256.Sum256(data[:len(data)-d.Len()])
+	if !bytes.Equal(d.Next(sha256.Size), sum[:]) {
+		return nil, ErrFormat
+	}
+	return h, nil
+}
+
+// Read and check the header of the space file fn, whoever it belongs to
+func readSpaceHeader(fn string) (*header, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, headerSize)
+	_, err = f.ReadAt(data, 0)
+	if err == io.EOF {
+		return nil, ErrFormat
+	} else if err != nil {
+		return nil, err
+	}
+	return parseHeader(data)
+}
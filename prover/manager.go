@@ -0,0 +1,248 @@
+package prover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kwonalbert/pospace/posgraph"
+	"github.com/kwonalbert/pospace/proof"
+	"github.com/kwonalbert/pospace/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Identifies one space among all the spaces of a host
+type PlotKey struct {
+	Pk        string // the bytes of the prover's public key
+	GraphType int
+	Index     int64
+	Plot      int64
+}
+
+func (k PlotKey) String() string {
+	return fmt.Sprintf("%x-%d-%d-%d", k.Pk, k.GraphType, k.Index, k.Plot)
+}
+
+func (p *Prover) Key() PlotKey {
+	return PlotKey{string(p.pk), posgraph.TYPE1, p.index, p.plot}
+}
+
+var ErrNoPlot = errors.New("prover: no such plot")
+
+// Keeps track of the plots (spaces) of a host, spread over one or more
+// directories, and routes challenges to them
+type Manager struct {
+	mu       sync.RWMutex
+	graphDir string
+	dirs     []string
+	plots    map[PlotKey]*plot
+}
+
+type plot struct {
+	p   *Prover // nil while Create initializes it
+	dir string
+}
+
+// Open every initialized space in dirs; spaces whose Init has not
+// finished are left alone (Create picks them up again)
+// Plots are created with the TYPE1 graphs in graphDir
+func NewManager(graphDir string, dirs ...string) (*Manager, error) {
+	if len(dirs) == 0 {
+		return nil, errors.New("prover: no space directories")
+	}
+	m := &Manager{
+		graphDir: graphDir,
+		dirs:     dirs,
+		plots:    make(map[PlotKey]*plot),
+	}
+	for _, dir := range dirs {
+		if err := m.scan(dir); err != nil {
+			m.Close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Manager) scan(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		// skip checkpoints and the like
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, spacePrefix) || strings.Contains(name, ".") {
+			continue
+		}
+		// an Init that did not get to write the header
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() < headerSize {
+			continue
+		}
+		fn := filepath.Join(dir, name)
+		h, err := readSpaceHeader(fn)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fn, err)
+		}
+		if len(h.root) == 0 {
+			continue
+		}
+		p, err := openSpace(fn, h.pk, h.index, h.plot, h.hash, m.graphDir)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fn, err)
+		}
+		key := p.Key()
+		if other, ok := m.plots[key]; ok {
+			p.Close()
+			return fmt.Errorf("prover: plot %v is in both %s and %s", key, other.dir, dir)
+		}
+		m.plots[key] = &plot{p: p, dir: dir}
+	}
+	return nil
+}
+
+// Initialize a new plot of pk and index (see NewPlot), with the lowest
+// plot id not in use, in the directory with the least committed bytes
+// An interrupted Create is resumed by the next Create for pk and index
+func (m *Manager) Create(ctx context.Context, pk []byte, index int64, hash int, progress util.ProgressFunc) (PlotKey, *Commitment, error) {
+	m.mu.Lock()
+	key := PlotKey{string(pk), posgraph.TYPE1, index, 0}
+	for ; m.plots[key] != nil; key.Plot++ {
+	}
+	dir := m.pickDir(pk, index, key.Plot)
+	m.plots[key] = &plot{dir: dir}
+	m.mu.Unlock()
+
+	p, err := NewPlot(pk, index, key.Plot, hash, m.graphDir, dir)
+	var commit *Commitment
+	if err == nil {
+		commit, err = p.InitContext(ctx, progress)
+		if err != nil {
+			p.Close()
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		delete(m.plots, key)
+		return PlotKey{}, nil, err
+	}
+	m.plots[key].p = p
+	return key, commit, nil
+}
+
+// The directory holding the unfinished space of the plot, if any, and
+// the one with the least committed bytes otherwise
+func (m *Manager) pickDir(pk []byte, index, plot int64) string {
+	used := make(map[string]int64)
+	for _, pl := range m.plots {
+		if pl.p != nil {
+			used[pl.dir] += pl.p.SpaceSize()
+		}
+	}
+	best := m.dirs[0]
+	for _, dir := range m.dirs {
+		if _, err := os.Stat(spaceFile(dir, pk, index, plot)); err == nil {
+			return dir
+		}
+		if used[dir] < used[best] {
+			best = dir
+		}
+	}
+	return best
+}
+
+// return: the keys of all initialized plots, in order
+func (m *Manager) Plots() []PlotKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var keys []PlotKey
+	for key, pl := range m.plots {
+		if pl.p != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Pk != b.Pk {
+			return a.Pk < b.Pk
+		}
+		if a.GraphType != b.GraphType {
+			return a.GraphType < b.GraphType
+		}
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		return a.Plot < b.Plot
+	})
+	return keys
+}
+
+// return: the prover of an initialized plot
+func (m *Manager) Prover(key PlotKey) (*Prover, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pl, ok := m.plots[key]
+	if !ok || pl.p == nil {
+		return nil, ErrNoPlot
+	}
+	return pl.p, nil
+}
+
+// return: the directory of an initialized plot
+func (m *Manager) Dir(key PlotKey) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pl, ok := m.plots[key]
+	if !ok || pl.p == nil {
+		return "", ErrNoPlot
+	}
+	return pl.dir, nil
+}
+
+// return: the bytes taken by all initialized plots
+func (m *Manager) CommittedBytes() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	total := int64(0)
+	for _, pl := range m.plots {
+		if pl.p != nil {
+			total += pl.p.SpaceSize()
+		}
+	}
+	return total
+}
+
+// Answer challenges with the plot of key, see Prover.ProveSpace
+func (m *Manager) ProveSpace(key PlotKey, challenges []int64) (*proof.Proof, error) {
+	p, err := m.Prover(key)
+	if err != nil {
+		return nil, err
+	}
+	return p.ProveSpace(challenges)
+}
+
+// Close the provers of all plots; a running Create is not interrupted
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	for key, pl := range m.plots {
+		if pl.p == nil {
+			continue
+		}
+		if cerr := pl.p.Close(); err == nil {
+			err = cerr
+		}
+		delete(m.plots, key)
+	}
+	return err
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package prover
+
+import (
+	"errors"
+	"os"
+)
+
+var errMmap = errors.New("prover: memory mapping is not supported on this platform")
+
+func mmapFile(f *os.File, size int64, writable bool) ([]byte, error) {
+	return nil, errMmap
+}
+
+func munmapFile(m []byte) error {
+	return errMmap
+}
+
+func msyncFile(m []byte) error {
+	return errMmap
+}
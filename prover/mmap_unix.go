@@ -0,0 +1,30 @@
+//go:build unix
+
+package prover
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+var errMmapSize = errors.New("prover: file too large to map")
+
+func mmapFile(f *os.File, size int64, writable bool) ([]byte, error) {
+	if int64(int(size)) != size {
+		return nil, errMmapSize
+	}
+	prot := unix.PROT_READ
+	if writable {
+		prot |= unix.PROT_WRITE
+	}
+	return unix.Mmap(int(f.Fd()), 0, int(size), prot, unix.MAP_SHARED)
+}
+
+func munmapFile(m []byte) error {
+	return unix.Munmap(m)
+}
+
+func msyncFile(m []byte) error {
+	return unix.Msync(m, unix.MS_SYNC)
+}
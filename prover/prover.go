@@ -1,35 +1,193 @@
 package prover
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/kwonalbert/pospace/posgraph"
+	"github.com/kwonalbert/pospace/proof"
 	"github.com/kwonalbert/pospace/util"
-	"golang.org/x/crypto/sha3"
+	"io"
 	"os"
+	"runtime"
+	"sync"
+	"time"
 )
 
 const hashSize = 32
 
+// Number of consecutive nodes initGraph schedules at a time
+const labelWindow = 1 << 16
+
+var (
+	ErrInvalidNode    = errors.New("prover: node is not in the graph")
+	ErrNotInitialized = errors.New("prover: space is not initialized")
+)
+
+// Tests set this to fail PutHash, e.g. as if the prover was killed
+var putHook func(id int64) error
+
+// Failure to read or write a node's hash in the space file
+type SpaceError struct {
+	Op  string // "read" or "write"
+	Id  int64  // post-order position of the hash in the space file
+	Err error
+}
+
+func (e *SpaceError) Error() string {
+	return fmt.Sprintf("prover: %s of hash %d failed: %v", e.Op, e.Id, e.Err)
+}
+
+func (e *SpaceError) Unwrap() error {
+	return e.Err
+}
+
+// Init has the prover to itself; once initialized, the space is only
+// read, and any number of goroutines can prove at once
 type Prover struct {
+	mu sync.RWMutex // write locked by Init, Close and the setters
+
 	pk    []byte
 	graph posgraph.Graph // storage for all the graphs
+	index int64
+	plot  int64 // tells apart the spaces of the same pk and index
+
+	commit []byte     // root hash of the merkle tree
+	fn     string     // name of the space file
+	space  *os.File   // file with the header, and the hashes if not sharded
+	layout layout     // of the hashes, see SetShards
+	shards []*os.File // by shard number, if sharded
+	maps   [][]byte   // of the files holding hashes, with MMAP storage
+	ckpt   string     // checkpoint file of an unfinished Init
 
-	commit []byte   // root hash of the merkle tree
-	space  *os.File // file that stores all hashes
+	hashType int                 // hash function, see util.HashFunc
+	hash     func([]byte) []byte // for the labels and the merkle tree
 
 	pow2  int64 // next closest power of 2 of size
 	log2  int64 // log2 of pow2
 	empty map[int64]bool
+
+	workers  int           // number of goroutines labeling the graph
+	storage  int           // FILEIO or MMAP
+	progress *util.Tracker // of the running Init; nil otherwise
 }
 
 type Commitment struct {
 	Pk     []byte
 	Commit []byte
+	Hash   int    // hash function used for the labels and the merkle tree
+	Graph  []byte // fingerprint of the graph, see verifier.VerifyGraph
+	Plot   int64  // see NewPlot and verifier.SetPlot
+}
+
+// hash selects the hash function for the labels and merkle tree
+// (util.SHA3, util.SHA256, util.BLAKE2B or util.ARGON2)
+// Init resumes the space for index in spaceDir if an earlier Init was
+// interrupted, and starts it over otherwise; see OpenProver to reuse a
+// complete space
+func NewProver(pk []byte, index int64, hash int, graphDir, spaceDir string) (*Prover, error) {
+	return NewPlot(pk, index, 0, hash, graphDir, spaceDir)
+}
+
+// NewProver for plot number plot of pk and index; each plot is a space
+// file of its own, so a host can keep several of the same pk and index
+// (see Manager). NewProver is plot 0
+// The plot goes into every label, so plots have different commitments
+// and each needs space of its own; verifiers learn it from
+// Commitment.Plot
+func NewPlot(pk []byte, index, plot int64, hash int, graphDir, spaceDir string) (*Prover, error) {
+	p, err := newProver(pk, index, plot, hash, graphDir)
+	if err != nil {
+		return nil, err
+	}
+
+	p.fn = spaceFile(spaceDir, pk, index, plot)
+	p.space, err = os.OpenFile(p.fn, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		p.graph.Close()
+		return nil, err
+	}
+	p.ckpt = p.fn + ".ckpt"
+	return p, nil
+}
+
+// Open the space initialized by an earlier prover, without touching the
+// labels; the space header must match pk, index and hash
+// return: a prover that is ready to prove (but not to Init)
+func OpenProver(pk []byte, index int64, hash int, graphDir, spaceDir string) (*Prover, error) {
+	return OpenPlot(pk, index, 0, hash, graphDir, spaceDir)
+}
+
+// OpenProver for plot number plot of pk and index, see NewPlot
+func OpenPlot(pk []byte, index, plot int64, hash int, graphDir, spaceDir string) (*Prover, error) {
+	return openSpace(spaceFile(spaceDir, pk, index, plot), pk, index, plot, hash, graphDir)
 }
 
-func NewProver(pk []byte, index int64, graphDir, spaceDir string) *Prover {
-	g := posgraph.NewGraph(posgraph.TYPE1, graphDir, index)
+// Open the initialized space in file fn, see OpenProver
+func openSpace(fn string, pk []byte, index, plot int64, hash int, graphDir string) (*Prover, error) {
+	p, err := newProver(pk, index, plot, hash, graphDir)
+	if err != nil {
+		return nil, err
+	}
+
+	p.fn = fn
+	p.space, err = os.Open(fn)
+	if err != nil {
+		p.graph.Close()
+		return nil, err
+	}
+
+	h, err := p.readHeader()
+	if err == nil && len(h.root) != hashSize {
+		err = ErrNotInitialized
+	}
+	if err == nil && !h.layout.valid() {
+		err = ErrFormat
+	}
+	if err == nil {
+		p.layout = h.layout
+		err = p.openShards(os.O_RDONLY)
+	}
+	if err == nil {
+		err = p.checkSize()
+	}
+	// the root goes in the header last, but check it made it to the space
+	var root []byte
+	if err == nil {
+		root, err = p.GetHash(2*p.pow2 - 1)
+	}
+	if err == nil && !bytes.Equal(root, h.root) {
+		err = ErrFormat
+	}
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+
+	p.commit = h.root
+	return p, nil
+}
+
+// Space files are named by pk (in hex), graph type, index and plot, so
+// the spaces of different provers can share a directory
+func spaceFile(spaceDir string, pk []byte, index, plot int64) string {
+	return fmt.Sprintf("%s/%s%x-%d-%d-%d", spaceDir, spacePrefix, pk, posgraph.TYPE1, index, plot)
+}
+
+const spacePrefix = "Space-"
+
+func newProver(pk []byte, index, plot int64, hash int, graphDir string) (*Prover, error) {
+	hashFunc, err := util.HashFunc(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := posgraph.NewGraph(posgraph.TYPE1, graphDir, index, nil, posgraph.BOLT)
+	if err != nil {
+		return nil, err
+	}
 
 	size := g.GetSize()
 	log2 := util.Log2(size) + 1
@@ -48,85 +206,385 @@ func NewProver(pk []byte, index int64, graphDir, spaceDir string) *Prover {
 		}
 	}
 
-	f, err := os.Create(fmt.Sprintf("%s/Space-%d", spaceDir, index))
-	if err != nil {
-		panic(err)
-	}
-
 	p := Prover{
 		pk:    pk,
 		graph: g,
-		space: f,
+		index: index,
+		plot:  plot,
+
+		hashType: hash,
+		hash:     hashFunc,
 
 		pow2:  pow2,
 		log2:  log2,
 		empty: empty,
+
+		workers: runtime.GOMAXPROCS(0),
+	}
+	return &p, nil
+}
+
+// Set the number of goroutines used to label the graph in Init
+func (p *Prover) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	p.mu.Lock()
+	p.workers = workers
+	p.mu.Unlock()
+}
+
+func (p *Prover) Index() int64 {
+	return p.index
+}
+
+func (p *Prover) Plot() int64 {
+	return p.plot
+}
+
+// Size of the space in bytes, once initialized
+func (p *Prover) SpaceSize() int64 {
+	return headerSize + 2*p.pow2*hashSize
+}
+
+// Waits for running proofs (and Init) to finish; proving afterwards fails
+// with ErrNotInitialized, and closing again does nothing
+func (p *Prover) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.space == nil {
+		return nil
+	}
+	p.graph.Close()
+	p.unmapSpace()
+	p.closeShards()
+	err := p.space.Close()
+	p.space = nil
+	p.commit = nil
+	return err
+}
+
+// Write the space header; root is empty while the space is initializing
+func (p *Prover) writeHeader(root []byte) error {
+	h := &header{
+		pk:        p.pk,
+		graphType: posgraph.TYPE1,
+		index:     p.index,
+		plot:      p.plot,
+		hash:      p.hashType,
+		nodes:     p.graph.GetSize(),
+		root:      root,
+		created:   time.Now(),
+		layout:    p.layout,
+	}
+	data, err := h.marshal()
+	if err != nil {
+		return err
+	}
+	_, err = p.space.WriteAt(data, 0)
+	if err != nil {
+		return &SpaceError{"write", -1, err}
 	}
-	return &p
+	return nil
 }
 
-func (p *Prover) GetHash(id int64) []byte {
+// Read the space header, and check that it belongs to this prover
+func (p *Prover) readHeader() (*header, error) {
+	data := make([]byte, headerSize)
+	_, err := p.space.ReadAt(data, 0)
+	if err == io.EOF {
+		return nil, ErrFormat
+	} else if err != nil {
+		return nil, &SpaceError{"read", -1, err}
+	}
+	h, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(h.pk, p.pk) || h.graphType != posgraph.TYPE1 || h.index != p.index ||
+		h.plot != p.plot || h.hash != p.hashType || h.nodes != p.graph.GetSize() {
+		return nil, ErrHeader
+	}
+	return h, nil
+}
+
+func (p *Prover) GetHash(id int64) ([]byte, error) {
 	data := make([]byte, hashSize)
-	n, err := p.space.ReadAt(data, id*hashSize)
-	if err != nil || n != hashSize {
-		panic(err)
+	k, off, err := p.locate(id)
+	if err != nil {
+		return nil, err
 	}
-	return data
+	if p.maps != nil {
+		m := p.maps[k]
+		if off < 0 || off+hashSize > int64(len(m)) {
+			return nil, &SpaceError{"read", id, io.EOF}
+		}
+		copy(data, m[off:])
+		return data, nil
+	}
+	_, err = p.hashFile(k).ReadAt(data, off)
+	if err != nil {
+		return nil, &SpaceError{"read", id, err}
+	}
+	return data, nil
 }
 
-func (p *Prover) PutHash(id int64, data []byte) {
-	n, err := p.space.WriteAt(data, id*hashSize)
-	if err != nil || n != hashSize {
-		panic(err)
+func (p *Prover) PutHash(id int64, data []byte) error {
+	if putHook != nil {
+		if err := putHook(id); err != nil {
+			return &SpaceError{"write", id, err}
+		}
 	}
+	k, off, err := p.locate(id)
+	if err != nil {
+		return err
+	}
+	if p.maps != nil {
+		m := p.maps[k]
+		if off < 0 || off+hashSize > int64(len(m)) {
+			return &SpaceError{"write", id, io.ErrShortWrite}
+		}
+		copy(m[off:off+hashSize], data)
+		return nil
+	}
+	_, err = p.hashFile(k).WriteAt(data, off)
+	if err != nil {
+		return &SpaceError{"write", id, err}
+	}
+	return nil
+}
+
+// Hash node i from the plot and the labels of its (already labeled)
+// parents
+func (p *Prover) labelNode(i int64, parents []int64) error {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf, uint64(p.plot))
+	binary.BigEndian.PutUint64(buf[8:], uint64(i))
+	val := append(append([]byte{}, p.pk...), buf...)
+	for _, parent := range parents {
+		pid := util.BfsToPost(p.pow2, p.log2, parent+p.pow2)
+		hash, err := p.GetHash(pid)
+		if err != nil {
+			return err
+		}
+		val = append(val, hash...)
+	}
+	id := util.BfsToPost(p.pow2, p.log2, i+p.pow2)
+	return p.PutHash(id, p.hash(val))
+}
+
+// Label all nodes in level across the workers
+// The nodes in a level must not depend on each other
+func (p *Prover) labelLevel(level []int64, parents [][]int64, start int64) error {
+	workers := p.workers
+	if workers > len(level) {
+		workers = len(level)
+	}
+	if workers <= 1 {
+		for _, i := range level {
+			err := p.labelNode(i, parents[i-start])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, workers)
+	chunk := (len(level) + workers - 1) / workers
+	for w := 0; w*chunk < len(level); w++ {
+		nodes := level[w*chunk:]
+		if len(nodes) > chunk {
+			nodes = nodes[:chunk]
+		}
+		wg.Add(1)
+		go func(w int, nodes []int64) {
+			defer wg.Done()
+			for _, i := range nodes {
+				err := p.labelNode(i, parents[i-start])
+				if err != nil {
+					errs[w] = err
+					return
+				}
+			}
+		}(w, nodes)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Assuming topo-sorted..
-func (p *Prover) initGraph() {
-	for i := int64(0); i < p.graph.GetSize(); i++ {
-		var ph []byte
-		parents := p.graph.GetParents(i)
-		for _, parent := range parents {
-			pid := util.BfsToPost(p.pow2, p.log2, parent+p.pow2)
-			ph = append(ph, p.GetHash(pid)...)
+// Nodes are scheduled labelWindow at a time: within a window, every node
+// is put in a level one deeper than its deepest parent in the window, so
+// the nodes of a level are independent and can be hashed concurrently
+// (parents before the window are already labeled)
+// Nodes before from are assumed to be labeled already
+func (p *Prover) initGraph(from int64) error {
+	size := p.graph.GetSize()
+	window := util.Min(labelWindow, checkpointNodes)
+	last := from
+	for start := from; start < size; start += window {
+		end := util.Min(start+window, size)
+
+		depth := make([]int, end-start)
+		parents := make([][]int64, end-start)
+		var levels [][]int64
+		for i := start; i < end; i++ {
+			ps, err := p.graph.GetParents(i)
+			if err != nil {
+				return err
+			}
+			d := 0
+			for _, parent := range ps {
+				if parent >= start && depth[parent-start]+1 > d {
+					d = depth[parent-start] + 1
+				}
+			}
+			depth[i-start] = d
+			parents[i-start] = ps
+			if d == len(levels) {
+				levels = append(levels, nil)
+			}
+			levels[d] = append(levels[d], i)
+		}
+
+		for _, level := range levels {
+			err := p.labelLevel(level, parents, start)
+			if err != nil {
+				return err
+			}
+			n := int64(len(level))
+			err = p.progress.Add(n, n*hashSize)
+			if err != nil {
+				return err
+			}
+		}
+
+		if end-last >= checkpointNodes && end < size {
+			err := p.saveCheckpoint(&checkpoint{labeled: end})
+			if err != nil {
+				return err
+			}
+			last = end
 		}
-		buf := make([]byte, 8)
-		binary.PutVarint(buf, i)
-		buf = append(p.pk, buf...)
-		buf = append(buf, ph...)
-		hash := sha3.Sum256(buf)
-		id := util.BfsToPost(p.pow2, p.log2, i+p.pow2)
-		p.PutHash(id, hash[:])
 	}
+	return nil
 }
 
 // Generate a merkle tree of the hashes of the vertices
 // return: root hash of the merkle tree
 //         will also write out the merkle tree
-func (p *Prover) Init() *Commitment {
+// An interrupted Init continues from its last checkpoint
+func (p *Prover) Init() (*Commitment, error) {
+	return p.InitContext(context.Background(), nil)
+}
+
+// Init, but stops with ctx.Err() once ctx is cancelled (a later Init
+// resumes from the last checkpoint), and is reported to progress (if not
+// nil) in labels plus merkle tree positions done, and bytes written
+func (p *Prover) InitContext(ctx context.Context, progress util.ProgressFunc) (*Commitment, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.progress = util.NewTracker(ctx, p.graph.GetSize()+2*p.pow2-1, progress)
+	defer func() { p.progress = nil }()
+
+	// the files may change size, so they are mapped again below
+	p.unmapSpace()
+	err := p.openShards(os.O_RDWR | os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+	c := p.loadCheckpoint()
+	if c == nil {
+		c = &checkpoint{}
+		err := p.truncate()
+		if err != nil {
+			return nil, err
+		}
+		err = p.writeHeader(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.mapSpace(true)
+
+	p.progress.Skip(c.labeled)
+	if c.merkle != nil {
+		p.progress.Skip(c.merkle.count - 1)
+	}
+
 	// build the merkle tree in depth first fashion
 	// root node is 1
-	p.initGraph()
-	root := p.generateMerkle()
+	if c.merkle == nil {
+		err := p.initGraph(c.labeled)
+		if err != nil {
+			return nil, err
+		}
+	}
+	root, err := p.resumeMerkle(c.merkle)
+	if err != nil {
+		return nil, err
+	}
+
+	// the root goes in last, so only complete spaces can be reopened
+	err = p.writeHeader(root)
+	if err != nil {
+		return nil, err
+	}
+	h, err := p.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(h.root, root) {
+		return nil, ErrHeader
+	}
+	os.Remove(p.ckpt)
 	p.commit = root
+	p.progress.Finish()
 
+	fp, err := p.graph.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
 	commit := &Commitment{
 		Pk:     p.pk,
 		Commit: root,
+		Hash:   p.hashType,
+		Graph:  fp,
+		Plot:   p.plot,
 	}
 
-	return commit
+	return commit, nil
 }
 
-// Read the commitment from pre-initialized graph
-func (p *Prover) PreInit() *Commitment {
-	hash := p.GetHash(2*p.pow2 - 1)
-	p.commit = hash
+// Commitment of a space initialized by Init, or checked by OpenProver
+func (p *Prover) PreInit() (*Commitment, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.commit == nil {
+		return nil, ErrNotInitialized
+	}
+	fp, err := p.graph.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
 	commit := &Commitment{
 		Pk:     p.pk,
 		Commit: p.commit,
+		Hash:   p.hashType,
+		Graph:  fp,
+		Plot:   p.plot,
 	}
-	return commit
+	return commit, nil
 }
 
 func (p *Prover) emptyMerkle(node int64) bool {
@@ -137,14 +595,50 @@ func (p *Prover) emptyMerkle(node int64) bool {
 // Iterative function to generate merkle tree
 // Should have at most O(lgn) hashes in memory at a time
 // return: the root hash
-func (p *Prover) generateMerkle() []byte {
+func (p *Prover) generateMerkle() ([]byte, error) {
+	return p.resumeMerkle(nil)
+}
+
+// Continue generateMerkle from state (nil to start from scratch), saving
+// a checkpoint every checkpointNodes hashes
+func (p *Prover) resumeMerkle(state *merkleState) ([]byte, error) {
 	var stack []int64
 	var hashStack [][]byte
 
 	cur := int64(1)
 	count := int64(1)
+	if state != nil {
+		cur, count = state.cur, state.count
+		stack, hashStack = state.stack, state.hashStack
+	}
+	last := count
+	reported := count
+	written := int64(0)
 
 	for count == 1 || len(stack) != 0 {
+		err := p.progress.Add(count-reported, written)
+		if err != nil {
+			return nil, err
+		}
+		reported, written = count, 0
+
+		if count-last >= checkpointNodes {
+			c := &checkpoint{
+				labeled: p.graph.GetSize(),
+				merkle: &merkleState{
+					cur:       cur,
+					count:     count,
+					stack:     append([]int64{}, stack...),
+					hashStack: append([][]byte{}, hashStack...),
+				},
+			}
+			err := p.saveCheckpoint(c)
+			if err != nil {
+				return nil, err
+			}
+			last = count
+		}
+
 		empty := p.emptyMerkle(cur)
 		for ; cur < 2*p.pow2 && !empty; cur *= 2 {
 			if cur < p.pow2 { //right child
@@ -173,7 +667,10 @@ func (p *Prover) generateMerkle() []byte {
 				hashStack = append(hashStack, make([]byte, hashSize))
 				count++
 			} else {
-				hash := p.GetHash(count)
+				hash, err := p.GetHash(count)
+				if err != nil {
+					return nil, err
+				}
 				count++
 				hashStack = append(hashStack, hash)
 			}
@@ -183,63 +680,161 @@ func (p *Prover) generateMerkle() []byte {
 			hash1 := hashStack[len(hashStack)-1]
 			hashStack = hashStack[:len(hashStack)-1]
 			val := append(hash1[:], hash2[:]...)
-			hash := sha3.Sum256(val)
+			hash := p.hash(val)
 
-			hashStack = append(hashStack, hash[:])
+			hashStack = append(hashStack, hash)
 
-			p.PutHash(count, hash[:])
+			err := p.PutHash(count, hash)
+			if err != nil {
+				return nil, err
+			}
+			written += hashSize
 			count++
 		}
 		cur = 2 * p.pow2
 	}
 
-	return hashStack[0]
+	err := p.progress.Add(count-reported, written)
+	if err != nil {
+		return nil, err
+	}
+	return hashStack[0], nil
+}
+
+// return: hash of the merkle tree node at bfs position pos
+func (p *Prover) merkleHash(pos int64) ([]byte, error) {
+	if pos >= p.pow2+p.graph.GetSize() || p.emptyMerkle(pos) {
+		return make([]byte, hashSize), nil
+	}
+	return p.GetHash(util.BfsToPost(p.pow2, p.log2, pos))
 }
 
 // Open a node in the merkle tree
 // return: hash of node, and the lgN hashes to verify node
-func (p *Prover) Open(node int64) ([]byte, [][]byte) {
-	hash := p.GetHash(util.BfsToPost(p.pow2, p.log2, node+p.pow2))
+func (p *Prover) Open(node int64) ([]byte, [][]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if node < 0 || node >= p.graph.GetSize() {
+		return nil, nil, ErrInvalidNode
+	}
+	hash, err := p.merkleHash(node + p.pow2)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	proof := make([][]byte, p.log2)
 	count := 0
 	for i := node + p.pow2; i > 1; i /= 2 { // root hash not needed, so >1
-		var sib int64
+		// need to send only the sibling
+		proof[count], err = p.merkleHash(i ^ 1)
+		if err != nil {
+			return nil, nil, err
+		}
+		count++
+	}
+	return hash, proof, nil
+}
+
+// Open a set of nodes in the merkle tree at once
+// return: hash of each node, and the multiproof for all of them
+func (p *Prover) OpenMulti(nodes []int64) ([][]byte, [][]byte, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.openMulti(nodes)
+}
 
-		if i%2 == 0 { // need to send only the sibling
-			sib = i + 1
-		} else {
-			sib = i - 1
+func (p *Prover) openMulti(nodes []int64) ([][]byte, [][]byte, error) {
+	hashes := make([][]byte, len(nodes))
+	for i, node := range nodes {
+		if node < 0 || node >= p.graph.GetSize() {
+			return nil, nil, ErrInvalidNode
 		}
+		var err error
+		hashes[i], err = p.merkleHash(node + p.pow2)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
-		if sib >= p.pow2+p.graph.GetSize() || p.emptyMerkle(sib) {
-			proof[count] = make([]byte, hashSize)
-		} else {
-			proof[count] = p.GetHash(util.BfsToPost(p.pow2, p.log2, sib))
+	sibs := proof.MultiNodes(p.pow2, nodes)
+	multi := make([][]byte, len(sibs))
+	for i, sib := range sibs {
+		var err error
+		multi[i], err = p.merkleHash(sib)
+		if err != nil {
+			return nil, nil, err
 		}
-		count++
 	}
-	return hash, proof
+	return hashes, multi, nil
 }
 
 // Receives challenges from the verifier to prove PoS
-// return: the hash values of the challenges, the parent hashes,
-//         the proof for each, and the proof for the parents
-func (p *Prover) ProveSpace(challenges []int64) ([][]byte, [][][]byte, [][][]byte, [][][][]byte) {
-	hashes := make([][]byte, len(challenges))
-	proofs := make([][][]byte, len(challenges))
-	parents := make([][][]byte, len(challenges))
-	pProofs := make([][][][]byte, len(challenges))
+// return: the proof with the hash values of the challenges, the parent
+//         hashes, and one multiproof for all of them
+func (p *Prover) ProveSpace(challenges []int64) (*proof.Proof, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.proveSpace(challenges)
+}
+
+func (p *Prover) proveSpace(challenges []int64) (*proof.Proof, error) {
+	if p.commit == nil {
+		return nil, ErrNotInitialized
+	}
+	pf := &proof.Proof{
+		Challenges: challenges,
+		Hashes:     make([][]byte, len(challenges)),
+		Parents:    make([][][]byte, len(challenges)),
+	}
+
+	parents := make([][]int64, len(challenges))
 	for i := range challenges {
-		hashes[i], proofs[i] = p.Open(challenges[i])
-		ps := p.graph.GetParents(challenges[i])
-		for _, parent := range ps {
-			if parent != -1 {
-				hash, proof := p.Open(parent)
-				parents[i] = append(parents[i], hash)
-				pProofs[i] = append(pProofs[i], proof)
-			}
+		ps, err := p.graph.GetParents(challenges[i])
+		if err != nil {
+			return nil, err
+		}
+		parents[i] = ps
+	}
+	hashes, multi, err := p.openMulti(proof.Nodes(challenges, parents))
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	for i := range challenges {
+		pf.Hashes[i] = hashes[count]
+		count++
+		for range parents[i] {
+			pf.Parents[i] = append(pf.Parents[i], hashes[count])
+			count++
 		}
 	}
-	return hashes, parents, proofs, pProofs
+	pf.Multi = multi
+	return pf, nil
+}
+
+// Prove space non-interactively for epoch, with beta*log2 challenges
+// derived from the commitment (see proof.FiatShamirSeed)
+func (p *Prover) ProveSpaceNI(epoch []byte, beta int) (*proof.NIProof, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.commit == nil {
+		return nil, ErrNotInitialized
+	}
+	seed := proof.FiatShamirSeed(p.pk, p.commit, epoch)
+	challenges := proof.Challenges(seed, beta*int(p.log2), p.graph.GetSize())
+	pf, err := p.proveSpace(challenges)
+	if err != nil {
+		return nil, err
+	}
+	np := &proof.NIProof{
+		Pk:     p.pk,
+		Commit: p.commit,
+		Hash:   p.hashType,
+		Epoch:  epoch,
+		Proof:  pf,
+	}
+	return np, nil
 }
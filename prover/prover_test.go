@@ -0,0 +1,661 @@
+package prover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kwonalbert/pospace/util"
+	"github.com/kwonalbert/pospace/verifier"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallelInit(t *testing.T) {
+	var roots [][]byte
+	for _, workers := range []int{1, 4} {
+		dir, _ := os.MkdirTemp("", "pospace")
+		defer os.RemoveAll(dir)
+
+		p, err := NewProver([]byte{1}, 4, util.SHA3, dir, dir)
+		if err != nil {
+			log.Fatal("New prover failed:", err)
+		}
+		p.SetWorkers(workers)
+		commit, err := p.Init()
+		if err != nil {
+			log.Fatal("Init failed:", err)
+		}
+		roots = append(roots, commit.Commit)
+	}
+	if !bytes.Equal(roots[0], roots[1]) {
+		log.Fatal("Parallel labeling changed the root:", roots)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+
+	pk := []byte{1}
+	p, err := NewProver(pk, 4, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("New prover failed:", err)
+	}
+	commit, err := p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+
+	audit := verifier.Audit{Fraction: 0.1, Soundness: 1e-6}
+	v, err := verifier.NewVerifier(pk, 4, util.SHA3, 1, commit.Commit, dir)
+	if err != nil {
+		log.Fatal("New verifier failed:", err)
+	}
+	challenges, err := v.AuditChallenges([]byte("seed"), audit)
+	if err != nil {
+		log.Fatal("Audit challenges failed:", err)
+	}
+	pf, err := p.ProveSpace(challenges)
+	if err != nil {
+		log.Fatal("Prove space failed:", err)
+	}
+	if !v.VerifyAudit(challenges, pf) {
+		log.Fatal("Honest prover failed the audit")
+	}
+
+	// commit to garbage for every other node
+	for i := int64(0); i < p.graph.GetSize(); i += 2 {
+		id := util.BfsToPost(p.pow2, p.log2, i+p.pow2)
+		p.PutHash(id, make([]byte, hashSize))
+	}
+	root, err := p.generateMerkle()
+	if err != nil {
+		log.Fatal("Merkle failed:", err)
+	}
+	p.commit = root
+
+	v, err = verifier.NewVerifier(pk, 4, util.SHA3, 1, root, dir)
+	if err != nil {
+		log.Fatal("New verifier failed:", err)
+	}
+	pf, err = p.ProveSpace(challenges)
+	if err != nil {
+		log.Fatal("Prove space failed:", err)
+	}
+	if v.VerifyAudit(challenges, pf) {
+		log.Fatal("Cheating prover passed the audit")
+	}
+}
+
+func TestOpenProver(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+
+	pk := []byte{1}
+	p, err := NewProver(pk, 4, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("New prover failed:", err)
+	}
+	commit, err := p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+	p.Close()
+
+	// spaces are named by pk, but the header has the final say
+	_, err = OpenProver([]byte{2}, 4, util.SHA3, dir, dir)
+	if !os.IsNotExist(err) {
+		log.Fatal("Opened the space of another pk:", err)
+	}
+	os.Rename(spaceFile(dir, pk, 4, 0), spaceFile(dir, []byte{2}, 4, 0))
+	_, err = OpenProver([]byte{2}, 4, util.SHA3, dir, dir)
+	if err != ErrHeader {
+		log.Fatal("Opened a space with the wrong pk:", err)
+	}
+	os.Rename(spaceFile(dir, []byte{2}, 4, 0), spaceFile(dir, pk, 4, 0))
+	_, err = OpenProver(pk, 4, util.SHA256, dir, dir)
+	if err != ErrHeader {
+		log.Fatal("Opened a space with the wrong hash:", err)
+	}
+
+	p, err = OpenProver(pk, 4, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("Open prover failed:", err)
+	}
+	defer p.Close()
+	if !bytes.Equal(p.commit, commit.Commit) {
+		log.Fatal("Reopened space has a different root")
+	}
+
+	v, err := verifier.NewVerifier(pk, 4, util.SHA3, 1, commit.Commit, dir)
+	if err != nil {
+		log.Fatal("New verifier failed:", err)
+	}
+	challenges := v.SelectChallenges([]byte("seed"))
+	pf, err := p.ProveSpace(challenges)
+	if err != nil {
+		log.Fatal("Prove space failed:", err)
+	}
+	if !v.VerifySpace(challenges, pf) {
+		log.Fatal("Reopened space failed to verify")
+	}
+
+	// the root in the header must match the merkle tree
+	f, _ := os.OpenFile(spaceFile(dir, pk, 4, 0), os.O_RDWR, 0)
+	f.WriteAt([]byte{0xff}, headerSize+(2*p.pow2-1)*hashSize)
+	f.Close()
+	_, err = OpenProver(pk, 4, util.SHA3, dir, dir)
+	if err != ErrFormat {
+		log.Fatal("Opened a space whose root does not match its header:", err)
+	}
+
+	// a space that was not initialized has no commitment yet
+	p, err = NewProver([]byte{2}, 4, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("New prover failed:", err)
+	}
+	defer p.Close()
+	if _, err = p.PreInit(); err != ErrNotInitialized {
+		log.Fatal("Pre init of an uninitialized space:", err)
+	}
+}
+
+func TestSpaceHeader(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+
+	pk := []byte{1}
+	p, err := NewProver(pk, 4, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("New prover failed:", err)
+	}
+	_, err = p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+
+	// a space that never finished Init has no root
+	err = p.writeHeader(nil)
+	if err != nil {
+		log.Fatal("Write header failed:", err)
+	}
+	_, err = OpenProver(pk, 4, util.SHA3, dir, dir)
+	if err != ErrNotInitialized {
+		log.Fatal("Opened an uninitialized space:", err)
+	}
+
+	// the checksum catches any change to the header
+	_, err = p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+	p.space.WriteAt([]byte{0xff}, 20)
+	p.Close()
+	_, err = OpenProver(pk, 4, util.SHA3, dir, dir)
+	if err != ErrFormat {
+		log.Fatal("Opened a space with a corrupt header:", err)
+	}
+}
+
+var errKilled = errors.New("prover: killed by test")
+
+func TestResumeInit(t *testing.T) {
+	defer func(n int64) { checkpointNodes = n }(checkpointNodes)
+	checkpointNodes = 16
+
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	p, err := NewProver([]byte{1}, 4, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("New prover failed:", err)
+	}
+	exp, err := p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+	p.Close()
+	writes := p.graph.GetSize() + 2*p.pow2
+
+	// PutHash fails once it has been called failAfter times (0 disables)
+	var failAfter, puts int64
+	putHook = func(int64) error {
+		if failAfter > 0 && atomic.AddInt64(&puts, 1) >= failAfter {
+			return errKilled
+		}
+		return nil
+	}
+	defer func() { putHook = nil }()
+
+	for trial := 0; trial < 20; trial++ {
+		dir, _ := os.MkdirTemp("", "pospace")
+		defer os.RemoveAll(dir)
+
+		// kill Init at random writes, possibly several times in a row
+		var kills []int64
+		for {
+			p, err = NewProver([]byte{1}, 4, util.SHA3, dir, dir)
+			if err != nil {
+				log.Fatal("New prover failed:", err)
+			}
+			p.SetWorkers(1 + trial%4)
+			failAfter, puts = 0, 0
+			if len(kills) < 3 && rand.Intn(4) != 0 {
+				failAfter = 1 + rand.Int63n(writes)
+				kills = append(kills, failAfter)
+			}
+			commit, err := p.Init()
+			if errors.Is(err, errKilled) {
+				if failAfter > 2*checkpointNodes && p.loadCheckpoint() == nil {
+					log.Fatal("No checkpoint after ", failAfter, " writes")
+				}
+				p.Close()
+				continue
+			}
+			p.Close()
+			if err != nil {
+				log.Fatal("Init failed:", err)
+			}
+			if !bytes.Equal(commit.Commit, exp.Commit) {
+				log.Fatal("Resuming after kills at ", kills, " changed the root")
+			}
+			break
+		}
+		if _, err := os.Stat(p.ckpt); err == nil {
+			log.Fatal("Checkpoint left behind after Init")
+		}
+	}
+}
+
+func TestInitContext(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+
+	p, err := NewProver([]byte{1}, 4, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("New prover failed:", err)
+	}
+	defer p.Close()
+	var last util.Progress
+	exp, err := p.InitContext(context.Background(), func(pr util.Progress) {
+		last = pr
+	})
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+	if last.Done != last.Total || last.Bytes == 0 {
+		log.Fatal("Init did not report completion:", last)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.InitContext(ctx, nil)
+	if !errors.Is(err, context.Canceled) {
+		log.Fatal("Cancelled Init did not stop:", err)
+	}
+	commit, err := p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+	if !bytes.Equal(commit.Commit, exp.Commit) {
+		log.Fatal("Init after cancelling changed the root")
+	}
+}
+
+func TestManager(t *testing.T) {
+	graphDir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(graphDir)
+	dirs := make([]string, 2)
+	for i := range dirs {
+		dirs[i], _ = os.MkdirTemp("", "pospace")
+		defer os.RemoveAll(dirs[i])
+	}
+
+	m, err := NewManager(graphDir, dirs...)
+	if err != nil {
+		log.Fatal("New manager failed:", err)
+	}
+	var keys []PlotKey
+	commits := make(map[PlotKey]*Commitment)
+	for _, pk := range [][]byte{{1}, {1}, {2}} {
+		key, commit, err := m.Create(context.Background(), pk, 4, util.SHA3, nil)
+		if err != nil {
+			log.Fatal("Create failed:", err)
+		}
+		keys = append(keys, key)
+		commits[key] = commit
+	}
+	if keys[0].Plot != 0 || keys[1].Plot != 1 || keys[2].Plot != 0 {
+		log.Fatal("Unexpected plot ids: ", keys)
+	}
+	// the plot goes into the labels
+	if bytes.Equal(commits[keys[0]].Commit, commits[keys[1]].Commit) {
+		log.Fatal("Plots of the same pk and index have the same root")
+	}
+	dir0, _ := m.Dir(keys[0])
+	dir1, _ := m.Dir(keys[1])
+	if dir0 == dir1 {
+		log.Fatal("Plots were not spread over the directories")
+	}
+	p, _ := m.Prover(keys[0])
+	if m.CommittedBytes() != 3*p.SpaceSize() {
+		log.Fatal("Unexpected committed bytes: ", m.CommittedBytes())
+	}
+	m.Close()
+
+	// a new manager finds the same plots, and proves with the right one
+	m, err = NewManager(graphDir, dirs...)
+	if err != nil {
+		log.Fatal("New manager failed:", err)
+	}
+	defer m.Close()
+	found := m.Plots()
+	if len(found) != 3 {
+		log.Fatal("Unexpected plots: ", found)
+	}
+	for _, key := range found {
+		commit := commits[key]
+		v, err := verifier.NewVerifier(commit.Pk, 4, util.SHA3, 1, commit.Commit, graphDir)
+		if err != nil {
+			log.Fatal("New verifier failed:", err)
+		}
+		v.SetPlot(commit.Plot)
+		challenges := v.SelectChallenges([]byte("seed"))
+		pf, err := m.ProveSpace(key, challenges)
+		if err != nil {
+			log.Fatal("Prove space failed:", err)
+		}
+		if !v.VerifySpace(challenges, pf) {
+			log.Fatal("Proof of plot ", key, " failed to verify")
+		}
+		v.SetPlot(commit.Plot + 1)
+		if v.VerifySpace(challenges, pf) {
+			log.Fatal("Proof of plot ", key, " verified as another plot")
+		}
+	}
+	_, err = m.ProveSpace(PlotKey{"\x03", 0, 4, 0}, []int64{0})
+	if err != ErrNoPlot {
+		log.Fatal("Proved with a missing plot:", err)
+	}
+}
+
+// Large and negative plot ids still give every plot its own labels
+func TestPlotIds(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+
+	roots := make(map[string]int64)
+	for _, plot := range []int64{0, 1 << 56, 1 << 57, -1, -1 << 56} {
+		p, err := NewPlot([]byte{1}, 4, plot, util.SHA3, dir, dir)
+		if err != nil {
+			log.Fatal("New plot failed:", err)
+		}
+		commit, err := p.Init()
+		if err != nil {
+			log.Fatal("Init failed:", err)
+		}
+		if other, ok := roots[string(commit.Commit)]; ok {
+			log.Fatal("Plots ", other, " and ", plot, " have the same root")
+		}
+		roots[string(commit.Commit)] = plot
+
+		v, err := verifier.NewVerifier([]byte{1}, 4, util.SHA3, 1, commit.Commit, dir)
+		if err != nil {
+			log.Fatal("New verifier failed:", err)
+		}
+		v.SetPlot(commit.Plot)
+		challenges := v.SelectChallenges([]byte("seed"))
+		pf, err := p.ProveSpace(challenges)
+		if err != nil {
+			log.Fatal("Prove space failed:", err)
+		}
+		if !v.VerifySpace(challenges, pf) {
+			log.Fatal("Proof of plot ", plot, " failed to verify")
+		}
+		p.Close()
+	}
+}
+
+func TestShards(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	disks := make([]string, 3)
+	for i := range disks {
+		disks[i], _ = os.MkdirTemp("", "pospace")
+		defer os.RemoveAll(disks[i])
+	}
+
+	p, err := NewProver([]byte{1}, 4, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("New prover failed:", err)
+	}
+	exp, err := p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+	if err := p.SetShards(100, disks...); err != ErrShards {
+		log.Fatal("Accepted a shard size that splits hashes:", err)
+	}
+
+	// no shards are open until Init
+	q, err := NewProver([]byte{2}, 4, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("New prover failed:", err)
+	}
+	if err := q.SetShards(1024, disks...); err != nil {
+		log.Fatal("Set shards failed:", err)
+	}
+	if _, err := q.PreInit(); err != ErrNotInitialized {
+		log.Fatal("Commitment of an uninitialized sharded space:", err)
+	}
+	if _, err := q.GetHash(0); err != ErrNotInitialized {
+		log.Fatal("Read a hash of an uninitialized sharded space:", err)
+	}
+	q.Close()
+
+	// shards that do not evenly divide the space
+	if err := p.SetShards(7*hashSize, disks...); err != nil {
+		log.Fatal("Set shards failed:", err)
+	}
+	commit, err := p.Init()
+	if err != nil {
+		log.Fatal("Init failed:", err)
+	}
+	p.Close()
+	if !bytes.Equal(commit.Commit, exp.Commit) {
+		log.Fatal("Sharded space has a different root")
+	}
+	info, _ := os.Stat(spaceFile(dir, []byte{1}, 4, 0))
+	if info.Size() != headerSize {
+		log.Fatal("Hashes were not moved to the shards")
+	}
+	for _, disk := range disks {
+		shards, _ := os.ReadDir(disk)
+		if len(shards) == 0 {
+			log.Fatal("No shards in ", disk)
+		}
+	}
+
+	// the layout comes from the header
+	p, err = OpenProver([]byte{1}, 4, util.SHA3, dir, dir)
+	if err != nil {
+		log.Fatal("Open prover failed:", err)
+	}
+	defer p.Close()
+	v, err := verifier.NewVerifier([]byte{1}, 4, util.SHA3, 1, commit.Commit, dir)
+	if err != nil {
+		log.Fatal("New verifier failed:", err)
+	}
+	challenges := v.SelectChallenges([]byte("seed"))
+	pf, err := p.ProveSpace(challenges)
+	if err != nil {
+		log.Fatal("Prove space failed:", err)
+	}
+	if !v.VerifySpace(challenges, pf) {
+		log.Fatal("Sharded space failed to verify")
+	}
+}
+
+func TestStorage(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(dir)
+	disk, _ := os.MkdirTemp("", "pospace")
+	defer os.RemoveAll(disk)
+
+	var roots [][]byte
+	for _, storage := range []int{FILEIO, MMAP} {
+		for _, shards := range []bool{false, true} {
+			p, err := NewProver([]byte{1}, 4, util.SHA3, dir, dir)
+			if err != nil {
+				log.Fatal("New prover failed:", err)
+			}
+			p.SetStorage(storage)
+			if shards {
+				p.SetShards(5*hashSize, dir, disk)
+			}
+			commit, err := p.Init()
+			if err != nil {
+				log.Fatal("Init failed:", err)
+			}
+			p.Close()
+			roots = append(roots, commit.Commit)
+
+			p, err = OpenProver([]byte{1}, 4, util.SHA3, dir, dir)
+			if err != nil {
+				log.Fatal("Open prover failed:", err)
+			}
+			p.SetStorage(storage)
+			if p.Storage() != storage && runtime.GOOS != "windows" {
+				log.Fatal("Storage ", storage, " is not in effect")
+			}
+			v, err := verifier.NewVerifier([]byte{1}, 4, util.SHA3, 1, commit.Commit, dir)
+			if err != nil {
+				log.Fatal("New verifier failed:", err)
+			}
+			challenges := v.SelectChallenges([]byte("seed"))
+			pf, err := p.ProveSpace(challenges)
+			if err != nil {
+				log.Fatal("Prove space failed:", err)
+			}
+			if !v.VerifySpace(challenges, pf) {
+				log.Fatal("Space with storage ", storage, " failed to verify")
+			}
+
+			// closing waits for running proofs, and later ones fail
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						_, err := p.ProveSpace(challenges)
+						if err == ErrNotInitialized {
+							return
+						} else if err != nil {
+							log.Fatal("Prove space failed:", err)
+						}
+					}
+				}()
+			}
+			if err := p.Close(); err != nil {
+				log.Fatal("Close failed:", err)
+			}
+			wg.Wait()
+			if err := p.Close(); err != nil {
+				log.Fatal("Second close failed:", err)
+			}
+		}
+	}
+	for _, root := range roots[1:] {
+		if !bytes.Equal(root, roots[0]) {
+			log.Fatal("Storage modes disagree on the root")
+		}
+	}
+}
+
+// Labeling (and merkle) throughput of each hash function
+func BenchmarkInit(b *testing.B) {
+	hashes := []int{util.SHA3, util.SHA256, util.BLAKE2B, util.ARGON2}
+	names := []string{"SHA3", "SHA256", "BLAKE2B", "ARGON2"}
+	for h := range hashes {
+		b.Run(names[h], func(b *testing.B) {
+			dir, _ := os.MkdirTemp("", "pospace")
+			defer os.RemoveAll(dir)
+			p, err := NewProver([]byte{1}, 6, hashes[h], dir, dir)
+			if err != nil {
+				log.Fatal("New prover failed:", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err = p.Init()
+				if err != nil {
+					log.Fatal("Init failed:", err)
+				}
+			}
+			b.ReportMetric(float64(p.graph.GetSize()*int64(b.N))/b.Elapsed().Seconds(), "nodes/s")
+		})
+	}
+}
+
+// Labeling a window of nodes, as in Init, and reading their hashes and
+// those of their parents in random order, as in proving, with each storage
+// mode
+// The spaces are sparse files of their full size, so large indices do not
+// need an Init first (but need the disk to support sparse files)
+// On one Xeon, with the spaces on tmpfs, MMAP labeled about twice and read
+// about ten times as fast as FILEIO, at every index
+func BenchmarkStorage(b *testing.B) {
+	const nodes = 1 << 12
+	names := []string{"FILEIO", "MMAP"}
+	for index := int64(15); index <= 20; index++ {
+		for storage, name := range names {
+			dir, _ := os.MkdirTemp("", "pospace")
+			defer os.RemoveAll(dir)
+			p, err := NewProver([]byte{1}, index, util.SHA3, dir, dir)
+			if err != nil {
+				log.Fatal("New prover failed:", err)
+			}
+			defer p.Close()
+			p.SetStorage(storage)
+			if err := p.space.Truncate(p.fileSize(0)); err != nil {
+				log.Fatal("Truncate failed:", err)
+			}
+			p.mapSpace(true)
+
+			start := rand.Int63n(p.graph.GetSize() - nodes)
+			parents := make([][]int64, nodes)
+			var ids []int64
+			for i := range parents {
+				parents[i], _ = p.graph.GetParents(start + int64(i))
+				for _, node := range append(parents[i], start+int64(i)) {
+					ids = append(ids, util.BfsToPost(p.pow2, p.log2, node+p.pow2))
+				}
+			}
+			b.Run(fmt.Sprintf("%d/%s/label", index, name), func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					for i := range parents {
+						if err := p.labelNode(start+int64(i), parents[i]); err != nil {
+							log.Fatal("Label failed:", err)
+						}
+					}
+				}
+				b.ReportMetric(float64(nodes*b.N)/b.Elapsed().Seconds(), "nodes/s")
+			})
+			b.Run(fmt.Sprintf("%d/%s/read", index, name), func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					for i := 0; i < nodes; i++ {
+						if _, err := p.GetHash(ids[rand.Intn(len(ids))]); err != nil {
+							log.Fatal("Read failed:", err)
+						}
+					}
+				}
+				b.ReportMetric(float64(nodes*b.N)/b.Elapsed().Seconds(), "hashes/s")
+			})
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package prover
+
+import (
+	"errors"
+	"fmt"
+	"github.com/kwonalbert/pospace/util"
+	"os"
+	"path/filepath"
+)
+
+var ErrShards = errors.New("prover: shard size must be a positive multiple of the hash size, with at least one directory")
+
+// Where the hashes of a space are stored
+// The hashes (hashSize bytes each, by post-order id) are cut into shards
+// of size bytes, and shard k goes in dirs[k%len(dirs)], so consecutive
+// shards are on different disks
+type layout struct {
+	size int64    // bytes per shard; 0 if the hashes follow the header
+	dirs []string // absolute paths
+}
+
+func (l *layout) valid() bool {
+	if l.size == 0 {
+		return len(l.dirs) == 0
+	}
+	return l.size > 0 && l.size%hashSize == 0 && len(l.dirs) > 0
+}
+
+func (l *layout) equal(o *layout) bool {
+	if l.size != o.size || len(l.dirs) != len(o.dirs) {
+		return false
+	}
+	for i := range l.dirs {
+		if l.dirs[i] != o.dirs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Spread the hashes over shard files of size bytes in dirs (round robin),
+// instead of keeping them in the space file, which only keeps the header;
+// the shards are named after the space file
+// Takes effect at the next Init, which starts over if an interrupted one
+// had another layout. OpenProver finds the layout in the header
+func (p *Prover) SetShards(size int64, dirs ...string) error {
+	l := layout{size: size}
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		l.dirs = append(l.dirs, abs)
+	}
+	if size == 0 || !l.valid() {
+		return ErrShards
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closeShards()
+	p.layout = l
+	return nil
+}
+
+func (p *Prover) numShards() int64 {
+	if p.layout.size == 0 {
+		return 0
+	}
+	return (2*p.pow2*hashSize + p.layout.size - 1) / p.layout.size
+}
+
+func (p *Prover) shardFile(k int64) string {
+	dir := p.layout.dirs[k%int64(len(p.layout.dirs))]
+	return filepath.Join(dir, fmt.Sprintf("%s.shard%d", filepath.Base(p.fn), k))
+}
+
+// Open the shard files of the layout (if not open yet) with flag, see
+// os.OpenFile
+func (p *Prover) openShards(flag int) error {
+	if p.shards != nil {
+		return nil
+	}
+	shards := make([]*os.File, p.numShards())
+	for k := range shards {
+		f, err := os.OpenFile(p.shardFile(int64(k)), flag, 0666)
+		if err != nil {
+			for _, f := range shards[:k] {
+				f.Close()
+			}
+			return err
+		}
+		shards[k] = f
+	}
+	p.shards = shards
+	return nil
+}
+
+func (p *Prover) closeShards() error {
+	var err error
+	for _, f := range p.shards {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	p.shards = nil
+	return err
+}
+
+// return: the number of the file (see hashFile) holding the hash with
+//         post-order id, and the offset of the hash in it;
+//         ErrNotInitialized if the shards are not open (SetShards before
+//         Init)
+func (p *Prover) locate(id int64) (int, int64, error) {
+	if p.layout.size == 0 {
+		return 0, headerSize + id*hashSize, nil
+	}
+	if len(p.shards) == 0 {
+		return 0, 0, ErrNotInitialized
+	}
+	off := id * hashSize
+	k := off / p.layout.size
+	if k >= int64(len(p.shards)) {
+		// past the space; let the read or write fail
+		k = int64(len(p.shards)) - 1
+	}
+	return int(k), off - k*p.layout.size, nil
+}
+
+// Number of files holding hashes: the space file, or the shards
+func (p *Prover) numFiles() int {
+	if p.layout.size == 0 {
+		return 1
+	}
+	return len(p.shards)
+}
+
+func (p *Prover) hashFile(k int) *os.File {
+	if p.layout.size == 0 {
+		return p.space
+	}
+	return p.shards[k]
+}
+
+// return: the size of file k once all of its hashes are written
+func (p *Prover) fileSize(k int) int64 {
+	total := 2 * p.pow2 * hashSize
+	if p.layout.size == 0 {
+		return headerSize + total
+	}
+	return util.Min(p.layout.size, total-int64(k)*p.layout.size)
+}
+
+// Check that the files hold all the hashes of the space
+// Only the end is checked, where the root goes last; the hashes of empty
+// merkle nodes are never written, so other shards may be short
+func (p *Prover) checkSize() error {
+	k := p.numFiles() - 1
+	info, err := p.hashFile(k).Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < p.fileSize(k) {
+		return ErrFormat
+	}
+	return nil
+}
+
+// Empty the space file and the shards, for an Init from scratch
+func (p *Prover) truncate() error {
+	err := p.space.Truncate(0)
+	for _, f := range p.shards {
+		if err == nil {
+			err = f.Truncate(0)
+		}
+	}
+	if err != nil {
+		return &SpaceError{"write", -1, err}
+	}
+	return nil
+}
+
+// Flush the space file and the shards (and their mappings) to disk
+func (p *Prover) sync() error {
+	err := p.syncMaps()
+	if err == nil {
+		err = p.space.Sync()
+	}
+	for _, f := range p.shards {
+		if err == nil {
+			err = f.Sync()
+		}
+	}
+	if err != nil {
+		return &SpaceError{"write", -1, err}
+	}
+	return nil
+}
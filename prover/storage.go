@@ -0,0 +1,87 @@
+package prover
+
+// Ways to read and write the hashes of a space
+const (
+	FILEIO = iota // a ReadAt or WriteAt per hash
+	MMAP          // memory mapped files; FILEIO where they cannot be mapped
+)
+
+// Set how the hashes are accessed (FILEIO by default); see Storage for
+// the mode in effect, as MMAP falls back on FILEIO
+// An initialized space is mapped right away, and Init maps the space
+// before labeling it. Must not be called while the prover is in use
+func (p *Prover) SetStorage(storage int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unmapSpace()
+	p.storage = storage
+	if p.commit != nil {
+		p.mapSpace(false)
+	}
+}
+
+// return: the storage mode in effect
+func (p *Prover) Storage() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.maps != nil {
+		return MMAP
+	}
+	return FILEIO
+}
+
+// Map the files holding hashes, if the storage mode is MMAP; when any of
+// them cannot be mapped, the space stays on FILEIO
+// A writable mapping first extends the files to their full size, and a
+// read-only one stops at the end of the file
+func (p *Prover) mapSpace(writable bool) {
+	if p.storage != MMAP || p.maps != nil {
+		return
+	}
+	maps := make([][]byte, p.numFiles())
+	for k := range maps {
+		f, size := p.hashFile(k), p.fileSize(k)
+		info, err := f.Stat()
+		if err == nil && info.Size() < size {
+			if writable {
+				err = f.Truncate(size)
+			} else {
+				size = info.Size()
+			}
+		}
+		if err == nil && size > 0 {
+			maps[k], err = mmapFile(f, size, writable)
+		}
+		if err != nil {
+			unmapFiles(maps[:k])
+			return
+		}
+	}
+	p.maps = maps
+}
+
+func (p *Prover) unmapSpace() {
+	unmapFiles(p.maps)
+	p.maps = nil
+}
+
+func unmapFiles(maps [][]byte) {
+	for _, m := range maps {
+		if m != nil {
+			munmapFile(m)
+		}
+	}
+}
+
+// Write the mapped hashes back to their files
+func (p *Prover) syncMaps() error {
+	for _, m := range p.maps {
+		if m == nil {
+			continue
+		}
+		if err := msyncFile(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
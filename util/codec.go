@@ -0,0 +1,138 @@
+package util
+
+import (
+	"encoding/binary"
+)
+
+// Big endian encoding shared by the wire and file formats; byte strings
+// are prefixed by their uint32 length
+type Encoder struct {
+	buf []byte
+}
+
+func (e *Encoder) Uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *Encoder) Uint16(v uint16) {
+	e.buf = binary.BigEndian.AppendUint16(e.buf, v)
+}
+
+func (e *Encoder) Uint32(v int) {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+}
+
+func (e *Encoder) Int64(v int64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+}
+
+// Append data as is, without a length
+func (e *Encoder) Raw(data []byte) {
+	e.buf = append(e.buf, data...)
+}
+
+func (e *Encoder) Bytes(data []byte) {
+	e.Uint32(len(data))
+	e.Raw(data)
+}
+
+// return: everything encoded so far
+func (e *Encoder) Data() []byte {
+	return e.buf
+}
+
+// Reads what an Encoder wrote
+// A decoder remembers the first error, so callers only check at the end;
+// reads after an error return zero values
+type Decoder struct {
+	buf       []byte
+	err       error
+	malformed error // reported for any malformed input
+}
+
+// malformed is the error to report if data is short or inconsistent
+func NewDecoder(data []byte, malformed error) *Decoder {
+	return &Decoder{buf: data, malformed: malformed}
+}
+
+// return: the next n bytes as they are (not copied), or nil after an
+//         error
+func (d *Decoder) Next(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.buf) {
+		d.err = d.malformed
+		return nil
+	}
+	res := d.buf[:n]
+	d.buf = d.buf[n:]
+	return res
+}
+
+func (d *Decoder) Uint8() uint8 {
+	b := d.Next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *Decoder) Uint16() uint16 {
+	b := d.Next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (d *Decoder) Uint32() int {
+	b := d.Next(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b))
+}
+
+func (d *Decoder) Int64() int64 {
+	b := d.Next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// return: a copy of the next length prefixed byte string
+func (d *Decoder) Bytes() []byte {
+	b := d.Next(d.Uint32())
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// Count reads a number of elements that each take at least min bytes,
+// so a corrupt count cannot trigger a huge allocation
+func (d *Decoder) Count(min int) int {
+	n := d.Uint32()
+	if d.err == nil && n*min > len(d.buf) {
+		d.err = d.malformed
+		return 0
+	}
+	return n
+}
+
+// return: the number of bytes not read yet
+func (d *Decoder) Len() int {
+	return len(d.buf)
+}
+
+func (d *Decoder) Err() error {
+	return d.err
+}
+
+// return: the first error, or the malformed error if anything is left
+//         unread
+func (d *Decoder) Finish() error {
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = d.malformed
+	}
+	return d.err
+}
@@ -0,0 +1,48 @@
+package util
+
+import (
+	"crypto/sha256"
+	"errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+)
+
+// Hash functions for the labels and the merkle tree
+// All of them produce 32 byte digests
+const (
+	SHA3    = iota // SHA3-256
+	SHA256  = iota
+	BLAKE2B = iota // BLAKE2b-256
+	ARGON2  = iota // Argon2id (1 pass over 64KiB); memory-hard, and slow
+)
+
+var ErrUnknownHash = errors.New("util: unknown hash function")
+
+var argon2Salt = []byte("pospace")
+
+// return: the hash function identified by h
+func HashFunc(h int) (func([]byte) []byte, error) {
+	switch h {
+	case SHA3:
+		return func(data []byte) []byte {
+			hash := sha3.Sum256(data)
+			return hash[:]
+		}, nil
+	case SHA256:
+		return func(data []byte) []byte {
+			hash := sha256.Sum256(data)
+			return hash[:]
+		}, nil
+	case BLAKE2B:
+		return func(data []byte) []byte {
+			hash := blake2b.Sum256(data)
+			return hash[:]
+		}, nil
+	case ARGON2:
+		return func(data []byte) []byte {
+			return argon2.IDKey(data, argon2Salt, 1, 64, 1, 32)
+		}, nil
+	}
+	return nil, ErrUnknownHash
+}
@@ -0,0 +1,117 @@
+package util
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress of a long running operation (graph generation, Init)
+type Progress struct {
+	Done  int64         // units of work done, e.g. nodes labeled
+	Total int64         // units of work in the whole operation
+	Bytes int64         // bytes written so far
+	ETA   time.Duration // estimated time left; 0 until there is an estimate
+}
+
+type ProgressFunc func(Progress)
+
+// Callbacks are at least this far apart, except for the one from Finish
+const progressInterval = 100 * time.Millisecond
+
+// Number of units of work between checks for cancellation
+const progressStep = 1 << 10
+
+// Counts the work done by an operation, reports it to a ProgressFunc,
+// and tells the operation when its context is cancelled
+// A nil *Tracker is valid, and never reports or cancels
+type Tracker struct {
+	ctx   context.Context
+	fn    ProgressFunc
+	total int64
+
+	done  int64 // atomic
+	bytes int64 // atomic
+
+	mu    sync.Mutex
+	next  int64     // done at which to check in next
+	base  int64     // work done before start (see Skip)
+	start time.Time // for the ETA
+	last  time.Time // time of the last callback
+}
+
+// fn may be nil, to only check ctx
+func NewTracker(ctx context.Context, total int64, fn ProgressFunc) *Tracker {
+	return &Tracker{
+		ctx:   ctx,
+		fn:    fn,
+		total: total,
+		start: time.Now(),
+	}
+}
+
+// Count work that was already done (e.g. by an interrupted run) without
+// crediting it to the current run's rate
+func (t *Tracker) Skip(done int64) {
+	if t == nil {
+		return
+	}
+	atomic.AddInt64(&t.done, done)
+	t.mu.Lock()
+	t.base += done
+	t.mu.Unlock()
+}
+
+// Count done units of work and bytes written; safe for concurrent use
+// return: the context's error once it is cancelled
+func (t *Tracker) Add(done, bytes int64) error {
+	if t == nil {
+		return nil
+	}
+	d := atomic.AddInt64(&t.done, done)
+	atomic.AddInt64(&t.bytes, bytes)
+	if d < atomic.LoadInt64(&t.next) {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if d < t.next {
+		return nil
+	}
+	atomic.StoreInt64(&t.next, d+progressStep)
+
+	if err := t.ctx.Err(); err != nil {
+		return err
+	}
+	now := time.Now()
+	if t.fn != nil && now.Sub(t.last) >= progressInterval {
+		t.last = now
+		t.fn(t.progress(d, now))
+	}
+	return nil
+}
+
+// Report the final progress, once the operation is done
+func (t *Tracker) Finish() {
+	if t == nil || t.fn == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fn(t.progress(atomic.LoadInt64(&t.done), time.Now()))
+}
+
+func (t *Tracker) progress(done int64, now time.Time) Progress {
+	p := Progress{
+		Done:  done,
+		Total: t.total,
+		Bytes: atomic.LoadInt64(&t.bytes),
+	}
+	if done > t.base && done < t.total {
+		elapsed := now.Sub(t.start)
+		p.ETA = time.Duration(float64(elapsed) * float64(t.total-done) / float64(done-t.base))
+	}
+	return p
+}
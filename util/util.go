@@ -1,10 +1,12 @@
 package util
 
 import (
-	"crypto/rand"
 	"encoding/binary"
+	"math"
 	"math/big"
 	"sort"
+
+	"golang.org/x/crypto/sha3"
 )
 
 // return: x^y
@@ -34,6 +36,13 @@ func Pow(x *big.Float, n int64) *big.Float {
 // return: nth root of x within some epsilon
 func Root(x *big.Float, n int64) *big.Float {
 	guess := new(big.Float).Quo(x, big.NewFloat(float64(n)))
+	// start from the float64 estimate when there is one; x/n converges
+	// very slowly for small x and large n
+	if xf, _ := x.Float64(); xf > 0 && !math.IsInf(xf, 0) {
+		if est := math.Pow(xf, 1/float64(n)); est > 0 && !math.IsInf(est, 0) {
+			guess = big.NewFloat(est)
+		}
+	}
 	diff := big.NewFloat(1)
 	ep := big.NewFloat(0.00000001)
 	abs := new(big.Float).Abs(diff)
@@ -113,27 +122,51 @@ func (a int64arr) Len() int           { return len(a) }
 func (a int64arr) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a int64arr) Less(i, j int) bool { return a[i] < a[j] }
 
-func Rand(bound int64) int64 {
-	buf := make([]byte, 8)
-	_, err := rand.Read(buf)
-	if err != nil {
-		panic(err)
+// Deterministic pseudorandom stream used for public graph generation.
+// The stream for (seed, keys...) is SHAKE256(len(seed)||seed||keys[0]||...)
+// with every integer encoded as 8 bytes big endian, so anyone holding
+// the public seed can regenerate the exact same values.
+type PRNG struct {
+	shake sha3.ShakeHash
+	buf   [8]byte
+}
+
+func NewPRNG(seed []byte, keys ...int64) *PRNG {
+	r := &PRNG{shake: sha3.NewShake256()}
+	binary.BigEndian.PutUint64(r.buf[:], uint64(len(seed)))
+	r.shake.Write(r.buf[:])
+	r.shake.Write(seed)
+	for _, k := range keys {
+		binary.BigEndian.PutUint64(r.buf[:], uint64(k))
+		r.shake.Write(r.buf[:])
 	}
-	v, _ := binary.Uvarint(buf)
-	res := int64(v % uint64(bound))
-	return res
+	return r
 }
 
-// return n values that ranges [l, u-1], sorted
-func NRandRange(l, u, n int64) []int64 {
-	seen := make([]bool, u-l)
-	var vals int64arr = make([]int64, n)
-	for i := range seen {
-		seen[i] = false
+func (r *PRNG) Uint64() uint64 {
+	r.shake.Read(r.buf[:])
+	return binary.BigEndian.Uint64(r.buf[:])
+}
+
+// return: uniform value in [0, bound-1] (rejection sampling, no modulo bias)
+func (r *PRNG) Rand(bound int64) int64 {
+	b := uint64(bound)
+	limit := math.MaxUint64 - math.MaxUint64%b
+	for {
+		v := r.Uint64()
+		if v < limit {
+			return int64(v % b)
+		}
 	}
+}
+
+// return n distinct values that ranges [l, u-1], sorted
+func (r *PRNG) NRandRange(l, u, n int64) []int64 {
+	seen := make(map[int64]bool)
+	var vals int64arr = make([]int64, n)
 	count := int64(0)
 	for count < n {
-		val := Rand(u - l)
+		val := r.Rand(u - l)
 		if !seen[val] {
 			seen[val] = true
 			vals[count] = val + l
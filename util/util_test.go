@@ -37,3 +37,21 @@ func TestUnion(t *testing.T) {
 	res := Union(l1, l2)
 	log.Println(exp, res)
 }
+
+func TestPRNG(t *testing.T) {
+	r1 := NewPRNG([]byte("seed"), 1, 2)
+	r2 := NewPRNG([]byte("seed"), 1, 2)
+	for i := 0; i < 100; i++ {
+		v1 := r1.Rand(7)
+		v2 := r2.Rand(7)
+		if v1 != v2 || v1 < 0 || v1 >= 7 {
+			log.Fatal("PRNG not deterministic:", v1, v2)
+		}
+	}
+	vals := NewPRNG([]byte("seed"), 3).NRandRange(10, 20, 10)
+	for i := range vals {
+		if vals[i] != int64(10+i) {
+			log.Fatal("NRandRange failed:", vals)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package verifier
+
+import (
+	"errors"
+	"github.com/kwonalbert/pospace/proof"
+	"math"
+)
+
+var ErrAuditParams = errors.New("verifier: audit parameters must be in (0, 1)")
+
+// Parameters of the initialization audit
+// After Init, the prover may have committed to labels that are not the
+// hash of their parents' labels. Each audited node catches this with
+// probability at least Fraction (if that many nodes are bad), so a prover
+// with more bad nodes passes with probability at most Soundness.
+type Audit struct {
+	Fraction  float64 // fraction of incorrectly labeled nodes to detect
+	Soundness float64 // max probability such a prover passes the audit
+}
+
+// return: number of audited nodes needed so that (1-Fraction)^n <= Soundness
+func (a Audit) Size() (int, error) {
+	if a.Fraction <= 0 || a.Fraction >= 1 || a.Soundness <= 0 || a.Soundness >= 1 {
+		return 0, ErrAuditParams
+	}
+	return int(math.Ceil(math.Log(a.Soundness) / math.Log(1-a.Fraction))), nil
+}
+
+// Select the nodes to audit the prover's initialization with
+// Size() nodes are chosen among all nodes, and as many again among the
+// sinks of the graph (its deepest nodes, which depend on all others), so
+// the bound holds both for the whole graph and for the sinks alone.
+// The prover answers with ProveSpace, checked with VerifyAudit
+func (v *Verifier) AuditChallenges(seed []byte, a Audit) ([]int64, error) {
+	n, err := a.Size()
+	if err != nil {
+		return nil, err
+	}
+
+	seedAll := append(append([]byte{}, seed...), 0)
+	seedSinks := append(append([]byte{}, seed...), 1)
+
+	challenges := proof.Challenges(seedAll, n, v.size)
+	sinks := int64(1) << uint64(v.index)
+	for _, c := range proof.Challenges(seedSinks, n, sinks) {
+		challenges = append(challenges, v.size-sinks+c)
+	}
+	return challenges, nil
+}
+
+// Check the prover's answer to the audit challenges
+func (v *Verifier) VerifyAudit(challenges []int64, pf *proof.Proof) bool {
+	return v.VerifySpace(challenges, pf)
+}
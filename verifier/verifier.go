@@ -1,17 +1,24 @@
 package verifier
 
 import (
+	"bytes"
 	"encoding/binary"
+	"github.com/kwonalbert/pospace/params"
 	"github.com/kwonalbert/pospace/posgraph"
+	"github.com/kwonalbert/pospace/proof"
 	"github.com/kwonalbert/pospace/util"
-	"golang.org/x/crypto/sha3"
 	//"log"
 )
 
 type Verifier struct {
-	pk   []byte // public key to verify the proof
-	beta int    // number of challenges needed
-	root []byte // root hash
+	pk   []byte              // public key to verify the proof
+	plot int64               // plot id of the prover's space
+	beta int                 // number of challenges needed
+	root []byte              // root hash
+	hash func([]byte) []byte // hash function recorded in the commitment
+
+	hashType int
+	sampling proof.Sampling // how SelectChallenges draws challenges
 
 	graph posgraph.Graph
 	index int64 // index of the graphy in the family
@@ -20,8 +27,19 @@ type Verifier struct {
 	log2  int64
 }
 
-func NewVerifier(pk []byte, index int64, beta int, root []byte, graphDir string) *Verifier {
-	graph := posgraph.NewGraph(posgraph.TYPE1, graphDir, index)
+// hash must be the hash function from the prover's commitment
+// beta*log2 challenges are selected; see NewVerifierSoundness to pick
+// beta for a target soundness
+func NewVerifier(pk []byte, index int64, hash int, beta int, root []byte, graphDir string) (*Verifier, error) {
+	hashFunc, err := util.HashFunc(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	graph, err := posgraph.NewGraph(posgraph.TYPE1, graphDir, index, nil, posgraph.BOLT)
+	if err != nil {
+		return nil, err
+	}
 	size := graph.GetSize()
 	log2 := util.Log2(size) + 1
 	pow2 := int64(1 << uint64(log2))
@@ -34,6 +52,9 @@ func NewVerifier(pk []byte, index int64, beta int, root []byte, graphDir string)
 		pk:   pk,
 		beta: beta,
 		root: root,
+		hash: hashFunc,
+
+		hashType: hash,
 
 		graph: graph,
 		index: index,
@@ -41,72 +62,151 @@ func NewVerifier(pk []byte, index int64, beta int, root []byte, graphDir string)
 		pow2:  pow2,
 		log2:  log2,
 	}
-	return &v
+	return &v, nil
 }
 
-//TODO: need to select based on some pseudorandomness/gamma function?
-//      Note that these challenges are different from those of cryptocurrency
-func (v *Verifier) SelectChallenges(seed []byte) []int64 {
-	challenges := make([]int64, v.beta*int(v.log2))
-	rands := make([]byte, v.beta*int(v.log2)*8)
-	sha3.ShakeSum256(rands, seed) //PRNG
-	for i := range challenges {
-		val, num := binary.Uvarint(rands[i*8 : (i+1)*8])
-		if num < 0 {
-			panic("Couldn't read PRNG")
-		}
-		challenges[i] = int64(val % uint64(v.size))
+// NewVerifier with at least the challenges params.MinBeta asks for: a
+// prover that discarded a fraction discard of its space, and cannot
+// recompute it in time, passes with probability at most soundness. This is
+// a lower bound that ignores the graph; it does not promise soundness for
+// a graph that is cheap to pebble
+func NewVerifierSoundness(pk []byte, index int64, hash int, discard, soundness float64, root []byte, graphDir string) (*Verifier, error) {
+	beta, err := params.MinBeta(posgraph.TYPE1, index, discard, soundness)
+	if err != nil {
+		return nil, err
 	}
+	return NewVerifier(pk, index, hash, beta, root, graphDir)
+}
+
+// Verify the plot of this id (Commitment.Plot); 0 by default
+func (v *Verifier) SetPlot(plot int64) {
+	v.plot = plot
+}
+
+// Check the graph fingerprint from the prover's commitment before
+// issuing any challenges
+// return: true iff the prover labeled the same graph as this verifier
+//         (false if this verifier's graph cannot be read)
+func (v *Verifier) VerifyGraph(fingerprint []byte) bool {
+	fp, err := v.graph.Fingerprint()
+	return err == nil && bytes.Equal(fp, fingerprint)
+}
+
+// Change how SelectChallenges draws its beta*log2 challenges, e.g. without
+// replacement or weighted towards the sinks
+// Non-interactive proofs always use uniform sampling
+func (v *Verifier) SetSampling(s proof.Sampling) error {
+	if err := s.Validate(v.beta*int(v.log2), v.size); err != nil {
+		return err
+	}
+	v.sampling = s
+	return nil
+}
+
+func (v *Verifier) SelectChallenges(seed []byte) []int64 {
+	challenges, _ := v.sampling.Sample(seed, v.beta*int(v.log2), v.size)
 	return challenges
 }
 
-func (v *Verifier) VerifySpace(challenges []int64, hashes [][]byte, parents [][][]byte, proofs [][][]byte, pProofs [][][][]byte) bool {
+// Check a non-interactive proof for the given epoch
+// return: true iff the proof is for this verifier's commitment, and
+//         answers the challenges derived from it
+func (v *Verifier) VerifyNI(epoch []byte, np *proof.NIProof) bool {
+	if !bytes.Equal(np.Pk, v.pk) || !bytes.Equal(np.Commit, v.root) ||
+		np.Hash != v.hashType || !bytes.Equal(np.Epoch, epoch) || np.Proof == nil {
+		return false
+	}
+	seed := proof.FiatShamirSeed(v.pk, v.root, epoch)
+	challenges := proof.Challenges(seed, v.beta*int(v.log2), v.size)
+	return v.VerifySpace(challenges, np.Proof)
+}
+
+// Check the prover's response to challenges
+// return: true iff every challenge is answered with a label consistent
+//         with its parents' labels, and all labels are in the merkle tree
+func (v *Verifier) VerifySpace(challenges []int64, pf *proof.Proof) bool {
+	multi := pf.Multi != nil
+	if len(pf.Challenges) != len(challenges) || len(pf.Hashes) != len(challenges) ||
+		len(pf.Parents) != len(challenges) {
+		return false
+	}
+	if !multi && (len(pf.Proofs) != len(challenges) || len(pf.PProofs) != len(challenges)) {
+		return false
+	}
+
+	parents := make([][]int64, len(challenges))
 	for i := range challenges {
-		buf := make([]byte, 8)
-		binary.PutVarint(buf, challenges[i])
-		val := append(v.pk, buf...)
-		for _, ph := range parents[i] {
-			val = append(val, ph...)
+		if pf.Challenges[i] != challenges[i] {
+			return false
 		}
-		exp := sha3.Sum256(val)
-		for j := range exp {
-			if exp[j] != hashes[i][j] {
-				return false
-			}
+
+		ps, err := v.graph.GetParents(challenges[i])
+		if err != nil || len(pf.Parents[i]) != len(ps) || (!multi && len(pf.PProofs[i]) != len(ps)) {
+			return false
+		}
+		parents[i] = ps
+
+		buf := make([]byte, 16)
+		binary.BigEndian.PutUint64(buf, uint64(v.plot))
+		binary.BigEndian.PutUint64(buf[8:], uint64(challenges[i]))
+		val := append(append([]byte{}, v.pk...), buf...)
+		for _, ph := range pf.Parents[i] {
+			val = append(val, ph...)
 		}
-		if !v.Verify(challenges[i], hashes[i], proofs[i]) {
+		if !bytes.Equal(v.hash(val), pf.Hashes[i]) {
 			return false
 		}
+		if multi {
+			continue
+		}
 
-		ps := v.graph.GetParents(challenges[i])
+		if !v.Verify(challenges[i], pf.Hashes[i], pf.Proofs[i]) {
+			return false
+		}
 		for j := range ps {
-			if !v.Verify(ps[j], parents[i][j], pProofs[i][j]) {
+			if !v.Verify(ps[j], pf.Parents[i][j], pf.PProofs[i][j]) {
 				return false
 			}
 		}
 	}
+
+	if multi {
+		return v.VerifyMulti(proof.Nodes(challenges, parents), pf.Labels(), pf.Multi)
+	}
 	return true
 }
 
+// Verify that all nodes have the given hashes using one multiproof
+func (v *Verifier) VerifyMulti(nodes []int64, hashes [][]byte, multi [][]byte) bool {
+	for _, node := range nodes {
+		if node < 0 || node >= v.size {
+			return false
+		}
+	}
+	root, err := proof.MultiRoot(v.pow2, nodes, hashes, multi, v.hash)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(v.root, root)
+}
+
 func (v *Verifier) Verify(node int64, hash []byte, proof [][]byte) bool {
+	if node < 0 || node >= v.size || int64(len(proof)) < v.log2 {
+		return false
+	}
+
 	curHash := hash
 	counter := 0
 	for i := node + v.pow2; i > 1; i /= 2 {
 		var val []byte
 		if i%2 == 0 {
-			val = append(curHash, proof[counter]...)
+			val = append(append(val, curHash...), proof[counter]...)
 		} else {
-			val = append(proof[counter], curHash...)
+			val = append(append(val, proof[counter]...), curHash...)
 		}
-		hash := sha3.Sum256(val)
-		curHash = hash[:]
+		curHash = v.hash(val)
 		counter++
 	}
-	for i := range v.root {
-		if v.root[i] != curHash[i] {
-			return false
-		}
-	}
 
-	return len(v.root) == len(curHash)
+	return bytes.Equal(v.root, curHash)
 }